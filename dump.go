@@ -0,0 +1,147 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/ksco/slua/lsp"
+	"github.com/ksco/slua/optimizer"
+	"github.com/ksco/slua/parser"
+	"github.com/ksco/slua/printer"
+	"github.com/ksco/slua/scanner"
+	"github.com/ksco/slua/syntax"
+)
+
+func openChunk(name string) (io.ReadCloser, error) {
+	if name == "-" {
+		return os.Stdin, nil
+	}
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, fmt.Errorf("cannot open %v", name)
+	}
+	return f, nil
+}
+
+// dumpTokens prints every token of the chunk, stopping at the first
+// scanner error.
+func dumpTokens(name string, w io.Writer) (err error) {
+	f, err := openChunk(name)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	defer func() {
+		if r := recover(); r != nil {
+			e, ok := r.(*scanner.Error)
+			if !ok {
+				panic(r)
+			}
+			err = fmt.Errorf("%v: %v", name, e)
+		}
+	}()
+
+	s := scanner.New(f)
+	for {
+		t := s.Scan()
+		fmt.Fprintln(w, syntax.FormatToken(t))
+		if t.Category == scanner.TokenEOF {
+			return nil
+		}
+	}
+}
+
+// dumpAST prints the syntax tree of the chunk as an outline, or as JSON,
+// optimized if optimize is set.
+func dumpAST(name string, asJSON, optimize bool, w io.Writer) error {
+	f, err := openChunk(name)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	tree, err := parser.ParseChunk(scanner.New(f))
+	if err != nil {
+		return fmt.Errorf("%v: %v", name, err)
+	}
+	if optimize {
+		tree = optimizer.Optimize(tree)
+	}
+	if !asJSON {
+		syntax.Fprint(w, tree)
+		return nil
+	}
+	enc := json.NewEncoder(w)
+	enc.SetEscapeHTML(false)
+	enc.SetIndent("", "  ")
+	return enc.Encode(tree)
+}
+
+// printJSON renders a syntax tree encoded as JSON back to Lua source.
+func printJSON(name string, w io.Writer) error {
+	f, err := openChunk(name)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	data, err := io.ReadAll(f)
+	if err != nil {
+		return err
+	}
+	tree, err := syntax.Unmarshal(data)
+	if err != nil {
+		return fmt.Errorf("%v: %v", name, err)
+	}
+	printer.Fprint(w, tree)
+	return nil
+}
+
+// subcommand runs 'slua tokens', 'slua ast', 'slua print', 'slua lsp' and
+// 'slua lint'. It reports false if args do not name a subcommand.
+func subcommand(args []string) (bool, error) {
+	if len(args) < 2 {
+		return false, nil
+	}
+	switch args[1] {
+	case "tokens":
+		if len(args) != 3 {
+			return true, fmt.Errorf("usage: %v tokens file", progName)
+		}
+		return true, dumpTokens(args[2], os.Stdout)
+	case "ast":
+		flags := flag.NewFlagSet(progName+" ast", flag.ContinueOnError)
+		asJSON := flags.Bool("json", false, "print the tree as JSON")
+		optimize := flags.Bool("O", false,
+			"fold constants and drop dead branches first")
+		if err := flags.Parse(args[2:]); err != nil {
+			return true, err
+		}
+		if flags.NArg() != 1 {
+			return true, fmt.Errorf("usage: %v ast [-json] [-O] file",
+				progName)
+		}
+		return true, dumpAST(flags.Arg(0), *asJSON, *optimize, os.Stdout)
+	case "lsp":
+		if len(args) != 2 {
+			return true, fmt.Errorf("usage: %v lsp", progName)
+		}
+		return true, lsp.New(os.Stdin, os.Stdout).Run()
+	case "lint":
+		clean, err := runLint(args[2:], os.Stdout)
+		if err == nil && !clean {
+			err = errors.New("lint found problems")
+		}
+		return true, err
+	case "print":
+		if len(args) != 3 {
+			return true, fmt.Errorf("usage: %v print file.json", progName)
+		}
+		return true, printJSON(args[2], os.Stdout)
+	}
+	return false, nil
+}
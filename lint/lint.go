@@ -0,0 +1,472 @@
+package lint
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/ksco/slua/parser"
+	"github.com/ksco/slua/resolver"
+	"github.com/ksco/slua/scanner"
+	"github.com/ksco/slua/syntax"
+)
+
+// Rule names, as used in configuration files and ignore comments.
+const (
+	RuleUnusedLocal       = "unused-local"
+	RuleShadowedLocal     = "shadowed-local"
+	RuleUndefinedGlobal   = "undefined-global"
+	RuleConstantCondition = "constant-condition"
+	RuleNilComparison     = "nil-comparison"
+)
+
+// Rules lists every rule the linter knows.
+var Rules = []string{
+	RuleUnusedLocal,
+	RuleShadowedLocal,
+	RuleUndefinedGlobal,
+	RuleConstantCondition,
+	RuleNilComparison,
+}
+
+const ignoreDirective = "slua:ignore"
+
+// Config selects the rules to run. Rules missing from the Rules map are
+// enabled. Globals lists the globals scripts may assign to.
+type Config struct {
+	Rules   map[string]bool `json:"rules"`
+	Globals []string        `json:"globals"`
+}
+
+// LoadConfig reads a JSON configuration file.
+func LoadConfig(path string) (*Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	config := new(Config)
+	if err := json.Unmarshal(data, config); err != nil {
+		return nil, fmt.Errorf("%v: %v", path, err)
+	}
+	for rule := range config.Rules {
+		if !isRule(rule) {
+			return nil, fmt.Errorf("%v: unknown rule '%v'", path, rule)
+		}
+	}
+	return config, nil
+}
+
+func isRule(name string) bool {
+	for _, rule := range Rules {
+		if rule == name {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *Config) enabled(rule string) bool {
+	on, ok := c.Rules[rule]
+	return !ok || on
+}
+
+// Warning is a problem found in a chunk.
+type Warning struct {
+	Line    int
+	Column  int
+	Rule    string
+	Message string
+}
+
+func (w *Warning) String() string {
+	return fmt.Sprintf("%v:%v: %v (%v)", w.Line, w.Column, w.Message, w.Rule)
+}
+
+type linter struct {
+	config   *Config
+	info     *resolver.Info
+	warnings []*Warning
+	// values holds every expression assigned to a local, with nil for an
+	// assignment that has no expression left.
+	values map[*resolver.Variable][]syntax.SyntaxTree
+	// nonNil caches nonNilLocal. A local being computed maps to false, so
+	// 'x = x' ends the recursion.
+	nonNil map[*resolver.Variable]bool
+}
+
+// Lint parses the chunk read from r and checks it. Scanner and parser
+// errors are returned as errors. A nil config enables every rule and
+// allows no globals.
+func Lint(r io.Reader, config *Config) (warnings []*Warning, err error) {
+	if config == nil {
+		config = new(Config)
+	}
+	src, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	tree, err := parser.ParseChunk(scanner.NewBytes(src))
+	if err != nil {
+		return nil, err
+	}
+
+	l := &linter{
+		config: config,
+		info:   resolver.Resolve(tree),
+		values: make(map[*resolver.Variable][]syntax.SyntaxTree),
+		nonNil: make(map[*resolver.Variable]bool),
+	}
+	l.collect(tree)
+	l.check(tree)
+	l.checkScope(l.info.Scope)
+
+	ignored := ignores(comments(src))
+	for _, w := range l.warnings {
+		rules, ok := ignored[w.Line]
+		if ok && (rules == nil || rules[w.Rule]) {
+			continue
+		}
+		if config.enabled(w.Rule) {
+			warnings = append(warnings, w)
+		}
+	}
+	sort.SliceStable(warnings, func(i, j int) bool {
+		a, b := warnings[i], warnings[j]
+		return a.Line < b.Line || a.Line == b.Line && a.Column < b.Column
+	})
+	return warnings, nil
+}
+
+// comment is a comment in a chunk. trailing is set if code comes before it
+// on its line.
+type comment struct {
+	token    *scanner.Token
+	trailing bool
+}
+
+// comments scans src, which has parsed, for its comments. Lines are counted
+// by the scanner, so they break where the parser's do.
+func comments(src []byte) []comment {
+	var list []comment
+	last := 0 // Line of the last token. No token spans lines.
+	s := scanner.NewBytes(src)
+	s.OnComment(func(t *scanner.Token) {
+		list = append(list, comment{t, t.Line == last})
+	})
+	for t := s.Scan(); t.Category != scanner.TokenEOF; t = s.Scan() {
+		last = t.Line
+	}
+	return list
+}
+
+// ignores finds the '-- slua:ignore [rule ...]' comments. A comment after
+// code covers its own line, a comment on a line of its own covers the next
+// one. A nil rule set covers every rule.
+func ignores(comments []comment) map[int]map[string]bool {
+	ignored := make(map[int]map[string]bool)
+	for _, c := range comments {
+		fields := strings.Fields(c.token.Value.(string))
+		if len(fields) == 0 || fields[0] != ignoreDirective {
+			continue
+		}
+		line := c.token.Line
+		if !c.trailing {
+			line++
+		}
+		var rules map[string]bool
+		if len(fields) > 1 {
+			rules = make(map[string]bool)
+			for _, rule := range fields[1:] {
+				rules[rule] = true
+			}
+		}
+		ignored[line] = rules
+	}
+	return ignored
+}
+
+func (l *linter) warn(t *scanner.Token, rule, format string,
+	a ...interface{}) {
+	l.warnings = append(l.warnings, &Warning{
+		Line:    t.Line,
+		Column:  t.Column,
+		Rule:    rule,
+		Message: fmt.Sprintf(format, a...),
+	})
+}
+
+// collect records the expressions assigned to every local.
+func (l *linter) collect(tree syntax.SyntaxTree) {
+	switch t := tree.(type) {
+	case *syntax.Chunk:
+		l.collect(t.Block)
+	case *syntax.Block:
+		for _, stmt := range t.Stmts {
+			l.collect(stmt)
+		}
+	case *syntax.DoStatement:
+		l.collect(t.Block)
+	case *syntax.WhileStatement:
+		l.collect(t.Block)
+	case *syntax.IfStatement:
+		l.collect(t.TrueBranch)
+		l.collect(t.FalseBranch)
+	case *syntax.ElseifStatement:
+		l.collect(t.TrueBranch)
+		l.collect(t.FalseBranch)
+	case *syntax.ElseStatement:
+		l.collect(t.Block)
+	case *syntax.LocalNameListStatement:
+		var vars []*resolver.Variable
+		for _, name := range t.NameList.(*syntax.NameList).Names {
+			vars = append(vars, l.info.Decls[name])
+		}
+		l.assign(vars, t.ExpList)
+	case *syntax.AssignmentStatement:
+		var vars []*resolver.Variable
+		for _, v := range t.VarList.(*syntax.VarList).VarList {
+			vars = append(vars, l.info.Names[v.(*syntax.Terminator)].Var)
+		}
+		l.assign(vars, t.ExpList)
+	}
+}
+
+func (l *linter) assign(vars []*resolver.Variable,
+	expList syntax.SyntaxTree) {
+	var exps []syntax.SyntaxTree
+	if expList != nil {
+		exps = expList.(*syntax.ExpressionList).ExpList
+	}
+	for i, v := range vars {
+		if v == nil {
+			continue
+		}
+		var exp syntax.SyntaxTree
+		if i < len(exps) {
+			exp = exps[i]
+		}
+		l.values[v] = append(l.values[v], exp)
+	}
+}
+
+// check looks for constant conditions, nil comparisons and assignments to
+// undefined globals.
+func (l *linter) check(tree syntax.SyntaxTree) {
+	switch t := tree.(type) {
+	case *syntax.Chunk:
+		l.check(t.Block)
+	case *syntax.Block:
+		for _, stmt := range t.Stmts {
+			l.check(stmt)
+		}
+	case *syntax.DoStatement:
+		l.check(t.Block)
+	case *syntax.WhileStatement:
+		// 'while true do' is the usual way to write an endless loop.
+		if !isTrue(t.Exp) {
+			l.condition(t.Exp, "while")
+		}
+		l.check(t.Exp)
+		l.check(t.Block)
+	case *syntax.IfStatement:
+		l.condition(t.Exp, "if")
+		l.check(t.Exp)
+		l.check(t.TrueBranch)
+		l.check(t.FalseBranch)
+	case *syntax.ElseifStatement:
+		l.condition(t.Exp, "elseif")
+		l.check(t.Exp)
+		l.check(t.TrueBranch)
+		l.check(t.FalseBranch)
+	case *syntax.ElseStatement:
+		l.check(t.Block)
+	case *syntax.LocalNameListStatement:
+		l.check(t.ExpList)
+	case *syntax.AssignmentStatement:
+		for _, v := range t.VarList.(*syntax.VarList).VarList {
+			term := v.(*syntax.Terminator)
+			name := term.Token.Value.(string)
+			res := l.info.Names[term]
+			if res.Kind == resolver.Global && !l.isGlobal(name) {
+				l.warn(term.Token, RuleUndefinedGlobal,
+					"setting undefined global '%v'", name)
+			}
+		}
+		l.check(t.ExpList)
+	case *syntax.ExpressionList:
+		for _, exp := range t.ExpList {
+			l.check(exp)
+		}
+	case *syntax.BinaryExpression:
+		if t.OpToken.Category == scanner.TokenEqual ||
+			t.OpToken.Category == scanner.TokenNotEqual {
+			l.nilComparison(t)
+		}
+		l.check(t.Left)
+		l.check(t.Right)
+	case *syntax.UnaryExpression:
+		l.check(t.Exp)
+	case *syntax.ParenExpression:
+		l.check(t.Exp)
+	}
+}
+
+func (l *linter) isGlobal(name string) bool {
+	for _, g := range l.config.Globals {
+		if g == name {
+			return true
+		}
+	}
+	return false
+}
+
+func (l *linter) condition(exp syntax.SyntaxTree, keyword string) {
+	if isConstant(exp) {
+		l.warn(syntax.FirstToken(exp), RuleConstantCondition,
+			"'%v' condition is always the same", keyword)
+	}
+}
+
+func (l *linter) nilComparison(t *syntax.BinaryExpression) {
+	other := t.Right
+	if isNil(t.Right) {
+		other = t.Left
+	} else if !isNil(t.Left) {
+		return
+	}
+	if l.isNonNil(other) {
+		result := "false"
+		if t.OpToken.Category == scanner.TokenNotEqual {
+			result = "true"
+		}
+		l.warn(t.OpToken, RuleNilComparison,
+			"comparison with nil is always %v", result)
+	}
+}
+
+// checkScope looks for unused and shadowed locals.
+func (l *linter) checkScope(s *resolver.Scope) {
+	for i, v := range s.Vars {
+		name := v.Name.Value.(string)
+		if len(v.Refs) == 0 && name != "_" {
+			if len(v.Writes) == 0 {
+				l.warn(v.Name, RuleUnusedLocal, "unused local '%v'", name)
+			} else {
+				l.warn(v.Name, RuleUnusedLocal,
+					"local '%v' is set but never read", name)
+			}
+		}
+		if outer := shadowed(s, i); outer != nil {
+			l.warn(v.Name, RuleShadowedLocal,
+				"local '%v' shadows the local on line %v", name,
+				outer.Name.Line)
+		}
+	}
+	for _, c := range s.Children {
+		l.checkScope(c)
+	}
+}
+
+// shadowed finds the local that s.Vars[i] hides, if any.
+func shadowed(s *resolver.Scope, i int) *resolver.Variable {
+	v := s.Vars[i]
+	name := v.Name.Value.(string)
+	for j := i - 1; j >= 0; j-- {
+		if s.Vars[j].Name.Value == name {
+			return s.Vars[j]
+		}
+	}
+	for s = s.Parent; s != nil; s = s.Parent {
+		for j := len(s.Vars) - 1; j >= 0; j-- {
+			outer := s.Vars[j]
+			if outer.Name.Value == name && outer.Name.Offset < v.Name.Offset {
+				return outer
+			}
+		}
+	}
+	return nil
+}
+
+// unparen strips the parentheses around exp.
+func unparen(exp syntax.SyntaxTree) syntax.SyntaxTree {
+	for {
+		p, ok := exp.(*syntax.ParenExpression)
+		if !ok {
+			return exp
+		}
+		exp = p.Exp
+	}
+}
+
+func isTrue(exp syntax.SyntaxTree) bool {
+	t, ok := unparen(exp).(*syntax.Terminator)
+	return ok && t.Token.Category == scanner.TokenTrue
+}
+
+func isNil(exp syntax.SyntaxTree) bool {
+	t, ok := unparen(exp).(*syntax.Terminator)
+	return ok && t.Token.Category == scanner.TokenNil
+}
+
+// isConstant reports whether exp is built from literals only.
+func isConstant(exp syntax.SyntaxTree) bool {
+	switch t := exp.(type) {
+	case *syntax.Terminator:
+		return t.Token.Category != scanner.TokenID &&
+			t.Token.Category != scanner.TokenVarArg
+	case *syntax.BinaryExpression:
+		return isConstant(t.Left) && isConstant(t.Right)
+	case *syntax.UnaryExpression:
+		return isConstant(t.Exp)
+	case *syntax.ParenExpression:
+		return isConstant(t.Exp)
+	}
+	return false
+}
+
+// isNonNil reports whether exp can never evaluate to nil.
+func (l *linter) isNonNil(exp syntax.SyntaxTree) bool {
+	switch t := exp.(type) {
+	case *syntax.Terminator:
+		switch t.Token.Category {
+		case scanner.TokenTrue, scanner.TokenFalse, scanner.TokenNumber,
+			scanner.TokenString:
+			return true
+		case scanner.TokenID:
+			if res := l.info.Names[t]; res.Var != nil {
+				return l.nonNilLocal(res.Var)
+			}
+		}
+		return false
+	case *syntax.BinaryExpression:
+		switch t.OpToken.Category {
+		case scanner.TokenAnd:
+			return l.isNonNil(t.Right) && l.isNonNil(t.Left)
+		case scanner.TokenOr:
+			return l.isNonNil(t.Right)
+		}
+		return true
+	case *syntax.UnaryExpression:
+		return true
+	case *syntax.ParenExpression:
+		return l.isNonNil(t.Exp)
+	}
+	return false
+}
+
+func (l *linter) nonNilLocal(v *resolver.Variable) bool {
+	if nonNil, ok := l.nonNil[v]; ok {
+		return nonNil
+	}
+	l.nonNil[v] = false
+	for _, exp := range l.values[v] {
+		if exp == nil || !l.isNonNil(exp) {
+			return false
+		}
+	}
+	l.nonNil[v] = true
+	return true
+}
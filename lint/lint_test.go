@@ -0,0 +1,229 @@
+package lint
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func lint(t *testing.T, src string, config *Config) []string {
+	t.Helper()
+	warnings, err := Lint(strings.NewReader(src), config)
+	if err != nil {
+		t.Fatalf("Lint(%q): %v", src, err)
+	}
+	var list []string
+	for _, w := range warnings {
+		list = append(list, w.String())
+	}
+	return list
+}
+
+var ruleTests = []struct {
+	name, src string
+	want      []string
+}{
+	{"clean", "local x = 1\ny = x\n", nil},
+
+	{"unused local", "local x = 1", []string{
+		"1:7: unused local 'x' (unused-local)",
+	}},
+	{"unused local without value", "local x, z = 1\ny = z", []string{
+		"1:7: unused local 'x' (unused-local)",
+	}},
+	{"local only assigned", "local x = 1\nx = 2", []string{
+		"1:7: local 'x' is set but never read (unused-local)",
+	}},
+	{"local read after assignment", "local x = 1\nx = 2\ny = x", nil},
+	{"local read in own assignment", "local x = 1\nx = x + 1", nil},
+	{"underscore", "local _ = 1", nil},
+
+	{"shadowed in same block", "local x = 1\nlocal x = x\ny = x", []string{
+		"2:7: local 'x' shadows the local on line 1 (shadowed-local)",
+	}},
+	{"shadowed in inner block",
+		"local x = 1\ndo\n    local x = 2\n    y = x\nend\ny = x",
+		[]string{
+			"3:11: local 'x' shadows the local on line 1 (shadowed-local)",
+		}},
+	{"sibling blocks", "do local x = 1 y = x end do local x = 2 y = x end",
+		nil},
+
+	{"undefined global", "x = 1", []string{
+		"1:1: setting undefined global 'x' (undefined-global)",
+	}},
+	{"reading a global", "local x = y\nz = x", []string{
+		"2:1: setting undefined global 'z' (undefined-global)",
+	}},
+
+	{"constant if", "if 1 < 2 then end", []string{
+		"1:4: 'if' condition is always the same (constant-condition)",
+	}},
+	{"constant elseif", "if a then elseif not nil then end", []string{
+		"1:18: 'elseif' condition is always the same " +
+			"(constant-condition)",
+	}},
+	{"constant while", "while false do end", []string{
+		"1:7: 'while' condition is always the same (constant-condition)",
+	}},
+	{"while true", "while true do end", nil},
+	{"while (true)", "while (true) do end", nil},
+	{"parenthesized constant", "if a or (1 < 2) then end", nil},
+	{"constant in parentheses", "if (1 < 2) then end", []string{
+		"1:4: 'if' condition is always the same (constant-condition)",
+	}},
+	{"condition with a name", "if a == 1 then end", nil},
+
+	{"nil compared with a literal", "if a then y = 1 == nil end", []string{
+		"1:17: comparison with nil is always false (nil-comparison)",
+	}},
+	{"nil compared with a set local",
+		"local n = 1\nif n ~= nil then end", []string{
+			"2:6: comparison with nil is always true (nil-comparison)",
+		}},
+	{"nil compared with a local that may be nil",
+		"local n\nif n == nil then end", nil},
+	{"nil compared with a local set to nil later",
+		"local n = 1\nn = nil\nif n == nil then end", nil},
+	{"nil compared with 'or'", "local n = a or 1\nif n == nil then end",
+		[]string{
+			"2:6: comparison with nil is always false (nil-comparison)",
+		}},
+	{"nil compared with 'and'", "local n = a and 1\nif n == nil then end",
+		nil},
+	{"nil in parentheses", "if (a .. \"\") ~= (nil) then end", []string{
+		"1:14: comparison with nil is always true (nil-comparison)",
+	}},
+}
+
+func TestRules(t *testing.T) {
+	config := &Config{Globals: []string{"y"}}
+	for _, test := range ruleTests {
+		got := lint(t, test.src, config)
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%v: got %q, want %q", test.name, got, test.want)
+		}
+	}
+}
+
+func TestIgnore(t *testing.T) {
+	tests := []struct {
+		name, src string
+		want      []string
+	}{
+		{"all rules on the line", "local x = 1 -- slua:ignore", nil},
+		{"all rules on the next line", "-- slua:ignore\nlocal x = 1", nil},
+		{"indented comment", "do\n    -- slua:ignore\n    local x = 1\nend",
+			nil},
+		{"named rule", "local x = 1 -- slua:ignore unused-local", nil},
+		{"several rules", "local x = 1\n" +
+			"local x = 2 -- slua:ignore shadowed-local unused-local",
+			[]string{"1:7: unused local 'x' (unused-local)"}},
+		{"other rule", "local x = 1 -- slua:ignore shadowed-local",
+			[]string{"1:7: unused local 'x' (unused-local)"}},
+		{"only its own line", "-- slua:ignore\nlocal x = 1\nlocal y = 2",
+			[]string{"3:7: unused local 'y' (unused-local)"}},
+		{"not the line before", "local x = 1\nlocal y = 2 -- slua:ignore",
+			[]string{"1:7: unused local 'x' (unused-local)"}},
+		{"not a directive", "local x = 1 -- slua:ignored",
+			[]string{"1:7: unused local 'x' (unused-local)"}},
+
+		// Lines break where the scanner breaks them.
+		{"CR line ends", "local x = 1 -- slua:ignore\r-- slua:ignore\r" +
+			"local y = 2\rlocal z = 3\r",
+			[]string{"4:7: unused local 'z' (unused-local)"}},
+		{"CRLF line ends", "-- slua:ignore\r\nlocal x = 1\r\nlocal y = 2",
+			[]string{"3:7: unused local 'y' (unused-local)"}},
+		{"LF-CR line ends", "-- slua:ignore\n\rlocal x = 1\n\rlocal y = 2",
+			[]string{"3:7: unused local 'y' (unused-local)"}},
+		{"comment after a blank line", "local x = 1\n\n-- slua:ignore\n" +
+			"local y = 2", []string{"1:7: unused local 'x' (unused-local)"}},
+		{"comment after a string", "local x = \"--\" -- slua:ignore\n" +
+			"local y = 2", []string{"2:7: unused local 'y' (unused-local)"}},
+	}
+	for _, test := range tests {
+		got := lint(t, test.src, &Config{})
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%v: got %q, want %q", test.name, got, test.want)
+		}
+	}
+}
+
+func TestConfig(t *testing.T) {
+	src := "local x = 1\nif true then g = 1 end"
+	got := lint(t, src, &Config{})
+	want := []string{
+		"1:7: unused local 'x' (unused-local)",
+		"2:4: 'if' condition is always the same (constant-condition)",
+		"2:14: setting undefined global 'g' (undefined-global)",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("default config: got %q, want %q", got, want)
+	}
+
+	config := &Config{
+		Rules: map[string]bool{
+			RuleUnusedLocal:       false,
+			RuleConstantCondition: true,
+		},
+		Globals: []string{"g"},
+	}
+	got = lint(t, src, config)
+	want = want[1:2]
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestNilConfig(t *testing.T) {
+	src := "local x = 1\nif true then g = 1 end"
+	got, want := lint(t, src, nil), lint(t, src, &Config{})
+	if len(want) != 3 || !reflect.DeepEqual(got, want) {
+		t.Errorf("nil config: got %q, want %q", got, want)
+	}
+}
+
+func TestLoadConfig(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, data string) string {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(data), 0666); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+
+	path := write("good.json",
+		`{"rules": {"shadowed-local": false}, "globals": ["print"]}`)
+	config, err := LoadConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.enabled(RuleShadowedLocal) || !config.enabled(RuleUnusedLocal) ||
+		!reflect.DeepEqual(config.Globals, []string{"print"}) {
+		t.Errorf("LoadConfig: %+v", config)
+	}
+
+	path = write("unknown.json", `{"rules": {"no-such-rule": true}}`)
+	if _, err := LoadConfig(path); err == nil ||
+		!strings.Contains(err.Error(), "unknown rule 'no-such-rule'") {
+		t.Errorf("unknown rule: %v", err)
+	}
+	path = write("bad.json", `{"rules": [}`)
+	if _, err := LoadConfig(path); err == nil {
+		t.Error("bad JSON: no error")
+	}
+	if _, err := LoadConfig(filepath.Join(dir, "missing.json")); err == nil {
+		t.Error("missing file: no error")
+	}
+}
+
+func TestSyntaxError(t *testing.T) {
+	for _, src := range []string{"local = 1", "x = \"abc"} {
+		if _, err := Lint(strings.NewReader(src), &Config{}); err == nil {
+			t.Errorf("%q: no error", src)
+		}
+	}
+}
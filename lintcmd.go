@@ -0,0 +1,60 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/ksco/slua/lint"
+)
+
+const defaultLintConfig = ".slualint.json"
+
+// runLint lints the files named in args and reports whether all of them
+// were clean.
+func runLint(args []string, w io.Writer) (bool, error) {
+	flags := flag.NewFlagSet(progName+" lint", flag.ContinueOnError)
+	configPath := flags.String("config", "",
+		"read rules and globals from `file` (default "+defaultLintConfig+
+			" if present)")
+	if err := flags.Parse(args); err != nil {
+		return false, err
+	}
+	if flags.NArg() == 0 {
+		return false, fmt.Errorf("usage: %v lint [-config file] file...",
+			progName)
+	}
+
+	config := &lint.Config{}
+	path := *configPath
+	if path == "" {
+		if _, err := os.Stat(defaultLintConfig); err == nil {
+			path = defaultLintConfig
+		}
+	}
+	if path != "" {
+		var err error
+		if config, err = lint.LoadConfig(path); err != nil {
+			return false, err
+		}
+	}
+
+	clean := true
+	for _, name := range flags.Args() {
+		f, err := openChunk(name)
+		if err != nil {
+			return false, err
+		}
+		warnings, err := lint.Lint(f, config)
+		f.Close()
+		if err != nil {
+			return false, fmt.Errorf("%v: %v", name, err)
+		}
+		for _, warning := range warnings {
+			fmt.Fprintf(w, "%v:%v\n", name, warning)
+			clean = false
+		}
+	}
+	return clean, nil
+}
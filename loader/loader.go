@@ -0,0 +1,133 @@
+// Package loader parses the Lua files of a file system, such as an
+// embed.FS, and keeps their syntax trees so that each version of a file is
+// scanned and parsed only once.
+package loader
+
+import (
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path"
+	"strings"
+	"sync"
+
+	"github.com/ksco/slua/parser"
+	"github.com/ksco/slua/scanner"
+	"github.com/ksco/slua/syntax"
+)
+
+// Loader loads chunks from a file system. It is safe to use from many
+// goroutines at once.
+//
+// Every Load reads the file and hashes its content, and the cached tree is
+// only used if the hash matches, so a file that changes is parsed again
+// rather than served stale. Trees are shared by every caller that loads
+// the same file and must not be modified.
+type Loader struct {
+	fsys    fs.FS
+	parse   func(*scanner.Scanner) (syntax.SyntaxTree, error)
+	mu      sync.Mutex
+	entries map[string]*entry
+}
+
+// entry is the result of parsing one version of a file. ready is closed
+// once tree and err are set.
+type entry struct {
+	sum   [sha256.Size]byte
+	ready chan struct{}
+	tree  syntax.SyntaxTree
+	err   error
+}
+
+func New(fsys fs.FS) *Loader {
+	l := new(Loader)
+	l.fsys = fsys
+	l.parse = parser.ParseChunk
+	l.entries = make(map[string]*entry)
+	return l
+}
+
+// Load returns the syntax tree of the file name, parsing it only if this
+// content has not been parsed before.
+func (l *Loader) Load(name string) (syntax.SyntaxTree, error) {
+	data, err := fs.ReadFile(l.fsys, name)
+	if err != nil {
+		return nil, err
+	}
+	sum := sha256.Sum256(data)
+
+	l.mu.Lock()
+	e := l.entries[name]
+	if e != nil && e.sum == sum {
+		l.mu.Unlock()
+		<-e.ready
+		return e.tree, e.err
+	}
+	e = &entry{sum: sum, ready: make(chan struct{})}
+	l.entries[name] = e
+	l.mu.Unlock()
+
+	parsed := false
+	defer func() {
+		// If parsing panicked, the loads waiting on e fail and the next
+		// one parses again.
+		if !parsed {
+			e.err = fmt.Errorf("%v: parsing panicked", name)
+			l.mu.Lock()
+			if l.entries[name] == e {
+				delete(l.entries, name)
+			}
+			l.mu.Unlock()
+		}
+		close(e.ready)
+	}()
+	e.tree, e.err = l.parse(scanner.NewBytes(data))
+	if e.err != nil {
+		e.err = fmt.Errorf("%v: %w", name, e.err)
+	}
+	parsed = true
+	return e.tree, e.err
+}
+
+// LoadAll loads every .lua file in the file system, stopping at the first
+// one that cannot be read or parsed.
+func (l *Loader) LoadAll() error {
+	return fs.WalkDir(l.fsys, ".", func(name string, d fs.DirEntry,
+		err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() || path.Ext(name) != ".lua" {
+			return nil
+		}
+		_, err = l.Load(name)
+		return err
+	})
+}
+
+// Find loads the chunk for a module name the way Lua's default searcher
+// would with the path "?.lua;?/init.lua": 'a.b' is 'a/b.lua' or
+// 'a/b/init.lua'.
+func (l *Loader) Find(module string) (syntax.SyntaxTree, error) {
+	base := strings.ReplaceAll(module, ".", "/")
+	var tried []string
+	for _, name := range []string{base + ".lua", base + "/init.lua"} {
+		tree, err := l.Load(name)
+		if err == nil {
+			return tree, nil
+		}
+		if !isNotExist(err) {
+			return nil, err
+		}
+		tried = append(tried, "\n\tno file '"+name+"'")
+	}
+	return nil, fmt.Errorf("module '%v' not found:%v", module,
+		strings.Join(tried, ""))
+}
+
+// isNotExist reports whether err means there is no such file. A path that
+// is not valid, like one with a '..' element, cannot name a file either.
+func isNotExist(err error) bool {
+	return errors.Is(err, fs.ErrNotExist) || errors.Is(err, fs.ErrInvalid)
+}
@@ -0,0 +1,299 @@
+package loader
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/ksco/slua/parser"
+	"github.com/ksco/slua/printer"
+	"github.com/ksco/slua/resolver"
+	"github.com/ksco/slua/scanner"
+	"github.com/ksco/slua/syntax"
+)
+
+func source(tree syntax.SyntaxTree) string {
+	var b strings.Builder
+	printer.Fprint(&b, tree)
+	return b.String()
+}
+
+// counting makes l count the chunks it parses.
+func counting(l *Loader) *int {
+	var mu sync.Mutex
+	n := new(int)
+	l.parse = func(s *scanner.Scanner) (syntax.SyntaxTree, error) {
+		mu.Lock()
+		*n++
+		mu.Unlock()
+		return parser.ParseChunk(s)
+	}
+	return n
+}
+
+func TestCacheHit(t *testing.T) {
+	fsys := fstest.MapFS{
+		"a.lua":      {Data: []byte("local x = 1")},
+		"same.lua":   {Data: []byte("local x = 1")},
+		"b/init.lua": {Data: []byte("y = 2")},
+	}
+	l := New(fsys)
+	parses := counting(l)
+
+	first, err := l.Load("a.lua")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 3; i++ {
+		tree, err := l.Load("a.lua")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if tree != first {
+			t.Error("cache hit returned a different tree")
+		}
+	}
+	if *parses != 1 {
+		t.Errorf("%v parses for one file loaded 4 times", *parses)
+	}
+
+	if tree, _ := l.Find("a"); tree != first {
+		t.Error("Find parsed a.lua again")
+	}
+	if _, err := l.Load("same.lua"); err != nil {
+		t.Fatal(err)
+	}
+	if err := l.LoadAll(); err != nil {
+		t.Fatal(err)
+	}
+	if *parses != 3 {
+		t.Errorf("%v parses for 3 files, want 3", *parses)
+	}
+}
+
+func TestChangedContent(t *testing.T) {
+	fsys := fstest.MapFS{"a.lua": {Data: []byte("local x = 1")}}
+	l := New(fsys)
+	parses := counting(l)
+
+	old, err := l.Load("a.lua")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fsys["a.lua"] = &fstest.MapFile{Data: []byte("local y = 2")}
+	tree, err := l.Load("a.lua")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tree == old {
+		t.Fatal("stale tree returned after the file changed")
+	}
+	if got := source(tree); got != "local y = 2\n" {
+		t.Errorf("got %q", got)
+	}
+
+	// Changing it back parses again too: only the latest version is
+	// kept.
+	fsys["a.lua"] = &fstest.MapFile{Data: []byte("local x = 1")}
+	if tree, _ := l.Load("a.lua"); source(tree) != "local x = 1\n" {
+		t.Errorf("got %q", source(tree))
+	}
+	if *parses != 3 {
+		t.Errorf("%v parses for 3 versions, want 3", *parses)
+	}
+
+	// A file that stops parsing reports the error and does not fall back
+	// to the last tree.
+	fsys["a.lua"] = &fstest.MapFile{Data: []byte("local = 1")}
+	if tree, err := l.Load("a.lua"); err == nil || tree != nil {
+		t.Errorf("broken file: tree %v, error %v", tree, err)
+	}
+}
+
+func TestErrors(t *testing.T) {
+	fsys := fstest.MapFS{
+		"bad.lua":   {Data: []byte("local = 1")},
+		"notes.txt": {Data: []byte("local =")},
+	}
+	l := New(fsys)
+	parses := counting(l)
+
+	_, err := l.Load("bad.lua")
+	var perr *parser.Error
+	if !errors.As(err, &perr) || !strings.HasPrefix(err.Error(), "bad.lua:") {
+		t.Errorf("error %v, want a parser error for bad.lua", err)
+	}
+	if _, again := l.Load("bad.lua"); again != err || *parses != 1 {
+		t.Errorf("a parse error is not cached: %v", again)
+	}
+	if err := l.LoadAll(); err == nil || !strings.Contains(err.Error(),
+		"bad.lua") {
+		t.Errorf("LoadAll: %v", err)
+	}
+	if _, err := l.Load("missing.lua"); !isNotExist(err) {
+		t.Errorf("missing file: %v", err)
+	}
+}
+
+func TestFind(t *testing.T) {
+	fsys := fstest.MapFS{
+		"a.lua":          {Data: []byte("x = 1")},
+		"b/init.lua":     {Data: []byte("x = 2")},
+		"c/d.lua":        {Data: []byte("x = 3")},
+		"bad/init.lua":   {Data: []byte("x =")},
+		"both.lua":       {Data: []byte("x = 4")},
+		"both/init.lua":  {Data: []byte("x = 5")},
+		"e/f/g/init.lua": {Data: []byte("x = 6")},
+	}
+	l := New(fsys)
+	for module, want := range map[string]string{
+		"a":     "x = 1\n",
+		"b":     "x = 2\n",
+		"c.d":   "x = 3\n",
+		"both":  "x = 4\n",
+		"e.f.g": "x = 6\n",
+	} {
+		tree, err := l.Find(module)
+		if err != nil {
+			t.Errorf("Find(%q): %v", module, err)
+		} else if got := source(tree); got != want {
+			t.Errorf("Find(%q) got %q, want %q", module, got, want)
+		}
+	}
+
+	_, err := l.Find("x.y")
+	want := "module 'x.y' not found:\n" +
+		"\tno file 'x/y.lua'\n" +
+		"\tno file 'x/y/init.lua'"
+	if err == nil || err.Error() != want {
+		t.Errorf("Find(\"x.y\") error:\n%v\nwant\n%v", err, want)
+	}
+	// A file that is found but does not parse is reported as such.
+	if _, err := l.Find("bad"); err == nil ||
+		!strings.HasPrefix(err.Error(), "bad/init.lua:") {
+		t.Errorf("Find(\"bad\"): %v", err)
+	}
+	// Names that are not valid paths are simply not found.
+	if _, err := l.Find("..a"); err == nil ||
+		!strings.HasPrefix(err.Error(), "module '..a' not found:") {
+		t.Errorf("Find(\"..a\"): %v", err)
+	}
+}
+
+func TestParsePanic(t *testing.T) {
+	fsys := fstest.MapFS{"a.lua": {Data: []byte("local x = 1")}}
+	l := New(fsys)
+	l.parse = func(*scanner.Scanner) (syntax.SyntaxTree, error) {
+		panic("boom")
+	}
+	func() {
+		defer func() {
+			if r := recover(); r != "boom" {
+				t.Errorf("recovered %v", r)
+			}
+		}()
+		l.Load("a.lua")
+	}()
+
+	l.parse = parser.ParseChunk
+	done := make(chan error)
+	go func() {
+		_, err := l.Load("a.lua")
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("load after a panic: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("load after a panic blocked")
+	}
+}
+
+func TestParsePanicWaiters(t *testing.T) {
+	fsys := fstest.MapFS{"a.lua": {Data: []byte("local x = 1")}}
+	l := New(fsys)
+	started := make(chan bool)
+	release := make(chan bool)
+	l.parse = func(*scanner.Scanner) (syntax.SyntaxTree, error) {
+		close(started)
+		<-release
+		panic("boom")
+	}
+	go func() {
+		defer func() { recover() }()
+		l.Load("a.lua")
+	}()
+	<-started
+	done := make(chan error)
+	go func() {
+		_, err := l.Load("a.lua")
+		done <- err
+	}()
+	// Give the second load time to start waiting on the first.
+	time.Sleep(10 * time.Millisecond)
+	close(release)
+	select {
+	case err := <-done:
+		if err == nil {
+			t.Error("waiter got no error")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("waiter blocked after a panic")
+	}
+}
+
+// TestSharedTree checks that the tree Find returns can be used by many
+// goroutines at once. Run it with -race.
+func TestSharedTree(t *testing.T) {
+	fsys := fstest.MapFS{
+		"m/init.lua": {Data: []byte("local a, b = 1, \"x\"\n" +
+			"local f = a + 3 * #b\n" +
+			"if a < 2 then g = f .. \"s\" else g = nil end\n" +
+			"while g do local c = #g g = c > 3 and nil end\n")},
+	}
+	l := New(fsys)
+	want, err := parser.ParseChunk(scanner.NewBytes(fsys["m/init.lua"].Data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	src := source(want)
+
+	const n = 50
+	trees := make([]syntax.SyntaxTree, n)
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			tree, err := l.Find("m")
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			trees[i] = tree
+			info := resolver.Resolve(tree)
+			if len(info.Scope.Vars) != 3 {
+				t.Errorf("%v top-level locals, want 3",
+					len(info.Scope.Vars))
+			}
+			if got := source(tree); got != src {
+				t.Errorf("printed %q, want %q", got, src)
+			}
+			if _, err := json.Marshal(tree); err != nil {
+				t.Error(err)
+			}
+		}(i)
+	}
+	wg.Wait()
+	for i, tree := range trees {
+		if tree != trees[0] {
+			t.Fatalf("goroutine %v got a different tree", i)
+		}
+	}
+}
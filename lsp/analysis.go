@@ -0,0 +1,427 @@
+package lsp
+
+import (
+	"sort"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/ksco/slua/resolver"
+	"github.com/ksco/slua/scanner"
+	"github.com/ksco/slua/syntax"
+)
+
+// pos is a position as the scanner counts it: lines and rune columns
+// from 1.
+type pos struct {
+	line, column int
+}
+
+func (p pos) before(q pos) bool {
+	return p.line < q.line || p.line == q.line && p.column < q.column
+}
+
+func tokenPos(t *scanner.Token) pos {
+	return pos{t.Line, t.Column}
+}
+
+// occurrence is one appearance of a name in the source.
+type occurrence struct {
+	token *scanner.Token
+	v     *resolver.Variable // nil for a global
+	decl  bool
+	// assign is set for a global that is assigned to here.
+	assign bool
+}
+
+func (o *occurrence) name() string {
+	return o.token.Value.(string)
+}
+
+func (o *occurrence) end() pos {
+	n := utf8.RuneCountInString(o.name())
+	return pos{o.token.Line, o.token.Column + n}
+}
+
+// extent is the part of the source a scope covers.
+type extent struct {
+	scope    *resolver.Scope
+	from, to pos
+}
+
+// analysis is what the server knows about a document that parsed.
+type analysis struct {
+	info        *resolver.Info
+	occurrences []*occurrence
+	extents     []*extent
+	types       map[*resolver.Variable]map[string]bool
+}
+
+func analyze(text string, tree syntax.SyntaxTree) *analysis {
+	a := &analysis{
+		info:  resolver.Resolve(tree),
+		types: make(map[*resolver.Variable]map[string]bool),
+	}
+	a.walk(tree)
+	a.findExtents(text)
+	sort.Slice(a.occurrences, func(i, j int) bool {
+		return tokenPos(a.occurrences[i].token).before(
+			tokenPos(a.occurrences[j].token))
+	})
+	return a
+}
+
+// walk records every occurrence of a name and the types of values assigned
+// to locals.
+func (a *analysis) walk(tree syntax.SyntaxTree) {
+	switch t := tree.(type) {
+	case *syntax.Chunk:
+		a.walk(t.Block)
+	case *syntax.Block:
+		for _, stmt := range t.Stmts {
+			a.walk(stmt)
+		}
+	case *syntax.DoStatement:
+		a.walk(t.Block)
+	case *syntax.WhileStatement:
+		a.walk(t.Exp)
+		a.walk(t.Block)
+	case *syntax.IfStatement:
+		a.walk(t.Exp)
+		a.walk(t.TrueBranch)
+		a.walk(t.FalseBranch)
+	case *syntax.ElseifStatement:
+		a.walk(t.Exp)
+		a.walk(t.TrueBranch)
+		a.walk(t.FalseBranch)
+	case *syntax.ElseStatement:
+		a.walk(t.Block)
+	case *syntax.LocalNameListStatement:
+		a.walk(t.ExpList)
+		names := t.NameList.(*syntax.NameList).Names
+		vars := make([]*resolver.Variable, len(names))
+		for i, name := range names {
+			vars[i] = a.info.Decls[name]
+			a.occurrences = append(a.occurrences,
+				&occurrence{token: name, v: vars[i], decl: true})
+		}
+		a.assign(vars, t.ExpList)
+	case *syntax.AssignmentStatement:
+		a.walk(t.ExpList)
+		var vars []*resolver.Variable
+		for _, v := range t.VarList.(*syntax.VarList).VarList {
+			term := v.(*syntax.Terminator)
+			res := a.info.Names[term]
+			vars = append(vars, res.Var)
+			a.occurrences = append(a.occurrences, &occurrence{
+				token:  term.Token,
+				v:      res.Var,
+				assign: res.Kind == resolver.Global,
+			})
+		}
+		a.assign(vars, t.ExpList)
+	case *syntax.ExpressionList:
+		for _, exp := range t.ExpList {
+			a.walk(exp)
+		}
+	case *syntax.BinaryExpression:
+		a.walk(t.Left)
+		a.walk(t.Right)
+	case *syntax.UnaryExpression:
+		a.walk(t.Exp)
+	case *syntax.ParenExpression:
+		a.walk(t.Exp)
+	case *syntax.Terminator:
+		if res, ok := a.info.Names[t]; ok {
+			a.occurrences = append(a.occurrences,
+				&occurrence{token: t.Token, v: res.Var})
+		}
+	}
+}
+
+func (a *analysis) assign(vars []*resolver.Variable,
+	expList syntax.SyntaxTree) {
+	var exps []syntax.SyntaxTree
+	if expList != nil {
+		exps = expList.(*syntax.ExpressionList).ExpList
+	}
+	for i, v := range vars {
+		if v == nil {
+			continue
+		}
+		typ := "nil"
+		if i < len(exps) {
+			typ = a.infer(exps[i])
+		} else if len(exps) > 0 && syntax.IsVarArg(exps[len(exps)-1]) {
+			typ = "any"
+		}
+		if a.types[v] == nil {
+			a.types[v] = make(map[string]bool)
+		}
+		a.types[v][typ] = true
+	}
+}
+
+// infer guesses the type of the value exp evaluates to.
+func (a *analysis) infer(exp syntax.SyntaxTree) string {
+	switch t := exp.(type) {
+	case *syntax.Terminator:
+		switch t.Token.Category {
+		case scanner.TokenNil:
+			return "nil"
+		case scanner.TokenTrue, scanner.TokenFalse:
+			return "boolean"
+		case scanner.TokenNumber:
+			return "number"
+		case scanner.TokenString:
+			return "string"
+		case scanner.TokenID:
+			if res := a.info.Names[t]; res.Var != nil {
+				return a.typeOf(res.Var)
+			}
+		}
+	case *syntax.UnaryExpression:
+		if t.OpToken.Category == scanner.TokenNot {
+			return "boolean"
+		}
+		return "number"
+	case *syntax.ParenExpression:
+		return a.infer(t.Exp)
+	case *syntax.BinaryExpression:
+		switch t.OpToken.Category {
+		case scanner.TokenAdd, scanner.TokenSub, scanner.TokenMul,
+			scanner.TokenDiv:
+			return "number"
+		case scanner.TokenConcat:
+			return "string"
+		case scanner.TokenAnd, scanner.TokenOr:
+			if left := a.infer(t.Left); left == a.infer(t.Right) {
+				return left
+			}
+		default:
+			return "boolean"
+		}
+	}
+	return "any"
+}
+
+// typeOf joins the types of every value assigned to v so far.
+func (a *analysis) typeOf(v *resolver.Variable) string {
+	var types []string
+	for typ := range a.types[v] {
+		types = append(types, typ)
+	}
+	if len(types) == 0 {
+		return "any"
+	}
+	sort.Strings(types)
+	return strings.Join(types, "|")
+}
+
+// findExtents matches the scopes the resolver built with the keywords that
+// open and close their blocks. Both come in source order: 'do', 'then' and
+// 'else' open a block, 'elseif', 'else' and 'end' close one.
+func (a *analysis) findExtents(text string) {
+	var scopes []*resolver.Scope
+	var preorder func(s *resolver.Scope)
+	preorder = func(s *resolver.Scope) {
+		scopes = append(scopes, s)
+		for _, c := range s.Children {
+			preorder(c)
+		}
+	}
+	preorder(a.info.Scope)
+
+	chunk := &extent{scope: scopes[0], from: pos{1, 1}}
+	a.extents = []*extent{chunk}
+	var open []*extent
+	s := scanner.New(strings.NewReader(text))
+	for {
+		t := s.Scan()
+		switch t.Category {
+		case scanner.TokenEOF:
+			chunk.to = tokenPos(t)
+			if len(a.extents) != len(scopes) {
+				a.extents = a.extents[:1]
+			}
+			return
+		case scanner.TokenEnd, scanner.TokenElseif, scanner.TokenElse:
+			if len(open) > 0 {
+				open[len(open)-1].to = tokenPos(t)
+				open = open[:len(open)-1]
+			}
+		}
+		switch t.Category {
+		case scanner.TokenDo, scanner.TokenThen, scanner.TokenElse:
+			if len(a.extents) < len(scopes) {
+				e := &extent{
+					scope: scopes[len(a.extents)],
+					from:  pos{t.Line, t.Column + len(t.Category)},
+				}
+				a.extents = append(a.extents, e)
+				open = append(open, e)
+			}
+		}
+	}
+}
+
+// scopeAt finds the innermost scope around p.
+func (a *analysis) scopeAt(p pos) *resolver.Scope {
+	scope := a.info.Scope
+	for _, e := range a.extents {
+		if !p.before(e.from) && !e.to.before(p) {
+			scope = e.scope
+		}
+	}
+	return scope
+}
+
+// visible returns the locals that can be named at p, innermost first.
+func (a *analysis) visible(p pos) []*resolver.Variable {
+	var vars []*resolver.Variable
+	seen := make(map[string]bool)
+	for s := a.scopeAt(p); s != nil; s = s.Parent {
+		for i := len(s.Vars) - 1; i >= 0; i-- {
+			v := s.Vars[i]
+			name := v.Name.Value.(string)
+			if !seen[name] && tokenPos(v.Name).before(p) {
+				seen[name] = true
+				vars = append(vars, v)
+			}
+		}
+	}
+	return vars
+}
+
+// occurrenceAt finds the name under or just before p.
+func (a *analysis) occurrenceAt(p pos) *occurrence {
+	for _, o := range a.occurrences {
+		if !p.before(tokenPos(o.token)) && !o.end().before(p) {
+			return o
+		}
+	}
+	return nil
+}
+
+// related returns every occurrence of the same variable as o, or of the
+// same global.
+func (a *analysis) related(o *occurrence) []*occurrence {
+	var list []*occurrence
+	for _, other := range a.occurrences {
+		if o.v != nil && other.v == o.v ||
+			o.v == nil && other.v == nil && other.name() == o.name() {
+			list = append(list, other)
+		}
+	}
+	return list
+}
+
+// globals returns the names of every global in the document.
+func (a *analysis) globals() []string {
+	var names []string
+	seen := make(map[string]bool)
+	for _, o := range a.occurrences {
+		if o.v == nil && !seen[o.name()] {
+			seen[o.name()] = true
+			names = append(names, o.name())
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// lineIndex maps between scanner positions and LSP positions in one
+// version of a document. Clients break lines at "\n", "\r\n" and "\r",
+// but the scanner also takes "\n\r" as a single break, so after one of
+// those the two count lines differently.
+type lineIndex struct {
+	// lines are the lines as the client counts them.
+	lines []string
+	// first[k] is the client line that scanner line k+1 starts on.
+	first []int
+}
+
+func newLineIndex(text string) *lineIndex {
+	x := &lineIndex{first: []int{0}}
+	start := 0
+	for i := 0; i < len(text); i++ {
+		ch := text[i]
+		if ch != '\r' && ch != '\n' {
+			continue
+		}
+		x.lines = append(x.lines, text[start:i])
+		if ch == '\r' && i+1 < len(text) && text[i+1] == '\n' {
+			i++
+		} else if ch == '\n' && i+1 < len(text) && text[i+1] == '\r' {
+			// The client sees an empty line between the two.
+			x.lines = append(x.lines, "")
+			i++
+		}
+		start = i + 1
+		x.first = append(x.first, len(x.lines))
+	}
+	x.lines = append(x.lines, text[start:])
+	return x
+}
+
+// clientLine returns the zero based client line of scanner line n.
+func (x *lineIndex) clientLine(n int) int {
+	if n < 1 {
+		return n - 1
+	}
+	if n > len(x.first) {
+		return x.first[len(x.first)-1] + n - len(x.first)
+	}
+	return x.first[n-1]
+}
+
+// toLSP converts p to a zero based line and UTF-16 character offset.
+func (x *lineIndex) toLSP(p pos) position {
+	lp := position{Line: x.clientLine(p.line)}
+	if lp.Line < 0 || lp.Line >= len(x.lines) {
+		return lp
+	}
+	n := 0
+	for _, ch := range x.lines[lp.Line] {
+		if n == p.column-1 {
+			break
+		}
+		lp.Character += utf16Len(ch)
+		n++
+	}
+	lp.Character += p.column - 1 - n
+	return lp
+}
+
+// fromLSP converts a zero based line and UTF-16 character offset to a pos.
+// The empty client line inside a "\n\r" belongs to the scanner line before
+// it.
+func (x *lineIndex) fromLSP(lp position) pos {
+	line := sort.Search(len(x.first), func(k int) bool {
+		return x.first[k] > lp.Line
+	})
+	p := pos{line: line, column: 1}
+	if lp.Line < 0 || lp.Line >= len(x.lines) {
+		p.line = lp.Line + 1
+		return p
+	}
+	if x.first[line-1] != lp.Line {
+		return p
+	}
+	units := 0
+	for _, ch := range x.lines[lp.Line] {
+		if units >= lp.Character {
+			return p
+		}
+		units += utf16Len(ch)
+		p.column++
+	}
+	p.column += lp.Character - units
+	return p
+}
+
+func utf16Len(ch rune) int {
+	if ch >= 0x10000 {
+		return 2
+	}
+	return 1
+}
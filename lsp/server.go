@@ -0,0 +1,397 @@
+package lsp
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/ksco/slua/parser"
+	"github.com/ksco/slua/scanner"
+)
+
+const source = "slua"
+
+// document is an open text document. a is the analysis of the last version
+// that parsed and aindex is the line index of that version, so that
+// symbols and completion keep working while the user is in the middle of
+// typing.
+type document struct {
+	uri    string
+	index  *lineIndex
+	a      *analysis
+	aindex *lineIndex
+}
+
+// Server is a language server speaking JSON-RPC over a pair of streams.
+type Server struct {
+	in       *bufio.Reader
+	out      io.Writer
+	docs     map[string]*document
+	shutdown bool
+}
+
+func New(in io.Reader, out io.Writer) *Server {
+	s := new(Server)
+	s.in = bufio.NewReader(in)
+	s.out = out
+	s.docs = make(map[string]*document)
+	return s
+}
+
+// Run serves requests until the client sends 'exit' or closes the input.
+// It returns an error if the client exits without asking for a shutdown
+// first.
+func (s *Server) Run() error {
+	for {
+		msg, err := s.read()
+		if err == io.EOF {
+			return errors.New("lsp: input closed before exit")
+		}
+		if err != nil {
+			return err
+		}
+		if msg == nil {
+			s.reply(nil, nil, &responseError{codeParseError, "invalid JSON"})
+			continue
+		}
+		if msg.Method == "exit" {
+			if !s.shutdown {
+				return errors.New("lsp: exit without shutdown")
+			}
+			return nil
+		}
+		if msg.ID == nil {
+			s.notified(msg)
+			continue
+		}
+		result, rerr := s.call(msg)
+		s.reply(msg.ID, result, rerr)
+	}
+}
+
+// read reads one message. It returns a nil message if the body is not
+// valid JSON.
+func (s *Server) read() (*message, error) {
+	header, err := textproto.NewReader(s.in).ReadMIMEHeader()
+	if err != nil {
+		return nil, err
+	}
+	length, err := strconv.Atoi(header.Get("Content-Length"))
+	if err != nil {
+		return nil, fmt.Errorf("lsp: bad Content-Length %q",
+			header.Get("Content-Length"))
+	}
+	body := make([]byte, length)
+	if _, err := io.ReadFull(s.in, body); err != nil {
+		return nil, err
+	}
+	msg := new(message)
+	if err := json.Unmarshal(body, msg); err != nil {
+		return nil, nil
+	}
+	return msg, nil
+}
+
+func (s *Server) write(v interface{}) {
+	body, err := json.Marshal(v)
+	if err != nil {
+		panic(err)
+	}
+	fmt.Fprintf(s.out, "Content-Length: %d\r\n\r\n%s", len(body), body)
+}
+
+func (s *Server) reply(id json.RawMessage, result interface{},
+	rerr *responseError) {
+	if id == nil {
+		id = json.RawMessage("null")
+	}
+	resp := &response{JSONRPC: "2.0", ID: id, Error: rerr}
+	if rerr == nil {
+		b, err := json.Marshal(result)
+		if err != nil {
+			panic(err)
+		}
+		resp.Result = b
+	}
+	s.write(resp)
+}
+
+func (s *Server) notify(method string, params interface{}) {
+	s.write(&notification{JSONRPC: "2.0", Method: method, Params: params})
+}
+
+func invalidParams(err error) *responseError {
+	return &responseError{codeInvalidParams, err.Error()}
+}
+
+func (s *Server) call(msg *message) (interface{}, *responseError) {
+	if s.shutdown {
+		return nil, &responseError{codeInvalidRequest, "server is shut down"}
+	}
+	switch msg.Method {
+	case "initialize":
+		return map[string]interface{}{
+			"capabilities": map[string]interface{}{
+				"textDocumentSync":       textDocumentSyncFull,
+				"documentSymbolProvider": true,
+				"definitionProvider":     true,
+				"referencesProvider":     true,
+				"hoverProvider":          true,
+				"completionProvider":     map[string]interface{}{},
+			},
+			"serverInfo": map[string]string{"name": source},
+		}, nil
+	case "shutdown":
+		s.shutdown = true
+		return nil, nil
+	case "textDocument/documentSymbol":
+		var params documentSymbolParams
+		if err := json.Unmarshal(msg.Params, &params); err != nil {
+			return nil, invalidParams(err)
+		}
+		return s.symbols(params.TextDocument.URI), nil
+	case "textDocument/definition":
+		var params textDocumentPositionParams
+		if err := json.Unmarshal(msg.Params, &params); err != nil {
+			return nil, invalidParams(err)
+		}
+		return s.definition(params.TextDocument.URI, params.Position), nil
+	case "textDocument/references":
+		var params referenceParams
+		if err := json.Unmarshal(msg.Params, &params); err != nil {
+			return nil, invalidParams(err)
+		}
+		return s.references(params.TextDocument.URI, params.Position,
+			params.Context.IncludeDeclaration), nil
+	case "textDocument/hover":
+		var params textDocumentPositionParams
+		if err := json.Unmarshal(msg.Params, &params); err != nil {
+			return nil, invalidParams(err)
+		}
+		return s.hover(params.TextDocument.URI, params.Position), nil
+	case "textDocument/completion":
+		var params textDocumentPositionParams
+		if err := json.Unmarshal(msg.Params, &params); err != nil {
+			return nil, invalidParams(err)
+		}
+		return s.completion(params.TextDocument.URI, params.Position), nil
+	}
+	return nil, &responseError{codeMethodNotFound,
+		"method not found: " + msg.Method}
+}
+
+func (s *Server) notified(msg *message) {
+	switch msg.Method {
+	case "textDocument/didOpen":
+		var params didOpenParams
+		if json.Unmarshal(msg.Params, &params) == nil {
+			s.update(params.TextDocument.URI, params.TextDocument.Text)
+		}
+	case "textDocument/didChange":
+		var params didChangeParams
+		if json.Unmarshal(msg.Params, &params) == nil &&
+			len(params.ContentChanges) > 0 {
+			changes := params.ContentChanges
+			s.update(params.TextDocument.URI, changes[len(changes)-1].Text)
+		}
+	case "textDocument/didClose":
+		var params didCloseParams
+		if json.Unmarshal(msg.Params, &params) == nil {
+			delete(s.docs, params.TextDocument.URI)
+			s.notify("textDocument/publishDiagnostics",
+				&publishDiagnosticsParams{
+					URI:         params.TextDocument.URI,
+					Diagnostics: []diagnostic{},
+				})
+		}
+	}
+}
+
+// update reanalyzes a document and publishes its diagnostics.
+func (s *Server) update(uri, text string) {
+	doc := s.docs[uri]
+	if doc == nil {
+		doc = &document{uri: uri}
+		s.docs[uri] = doc
+	}
+	doc.index = newLineIndex(text)
+	tree, err := parser.ParseChunk(scanner.New(strings.NewReader(text)))
+	if err == nil {
+		doc.a = analyze(text, tree)
+		doc.aindex = doc.index
+	}
+
+	diags := []diagnostic{}
+	if e, ok := err.(interface {
+		Position() (int, int)
+		Message() string
+	}); ok {
+		line, column := e.Position()
+		start := doc.index.toLSP(pos{line, column})
+		end := start
+		end.Character++
+		diags = append(diags, diagnostic{
+			Range:    textRange{start, end},
+			Severity: severityError,
+			Source:   source,
+			Message:  e.Message(),
+		})
+	}
+	s.notify("textDocument/publishDiagnostics",
+		&publishDiagnosticsParams{URI: uri, Diagnostics: diags})
+}
+
+func (d *document) rangeOf(o *occurrence) textRange {
+	return textRange{
+		Start: d.aindex.toLSP(tokenPos(o.token)),
+		End:   d.aindex.toLSP(o.end()),
+	}
+}
+
+func (d *document) location(o *occurrence) location {
+	return location{URI: d.uri, Range: d.rangeOf(o)}
+}
+
+func (s *Server) occurrenceAt(uri string, lp position) (*document,
+	*occurrence) {
+	doc := s.docs[uri]
+	if doc == nil || doc.a == nil {
+		return nil, nil
+	}
+	return doc, doc.a.occurrenceAt(doc.aindex.fromLSP(lp))
+}
+
+func (s *Server) symbols(uri string) []documentSymbol {
+	symbols := []documentSymbol{}
+	doc := s.docs[uri]
+	if doc == nil || doc.a == nil {
+		return symbols
+	}
+	for _, o := range doc.a.occurrences {
+		if o.decl {
+			r := doc.rangeOf(o)
+			symbols = append(symbols, documentSymbol{
+				Name:           o.name(),
+				Detail:         doc.a.typeOf(o.v),
+				Kind:           symbolKindVariable,
+				Range:          r,
+				SelectionRange: r,
+			})
+		}
+	}
+	return symbols
+}
+
+func (s *Server) definition(uri string, lp position) []location {
+	locations := []location{}
+	doc, o := s.occurrenceAt(uri, lp)
+	if o == nil {
+		return locations
+	}
+	for _, other := range doc.a.related(o) {
+		if other.decl || other.assign {
+			locations = append(locations, doc.location(other))
+			if other.decl {
+				break
+			}
+		}
+	}
+	return locations
+}
+
+func (s *Server) references(uri string, lp position,
+	includeDecl bool) []location {
+	locations := []location{}
+	doc, o := s.occurrenceAt(uri, lp)
+	if o == nil {
+		return locations
+	}
+	for _, other := range doc.a.related(o) {
+		if includeDecl || !other.decl {
+			locations = append(locations, doc.location(other))
+		}
+	}
+	return locations
+}
+
+func (s *Server) hover(uri string, lp position) *hover {
+	doc, o := s.occurrenceAt(uri, lp)
+	if o == nil {
+		return nil
+	}
+	text := "global " + o.name()
+	if o.v != nil {
+		text = "local " + o.name() + ": " + doc.a.typeOf(o.v)
+	}
+	return &hover{
+		Contents: markupContent{
+			Kind:  "markdown",
+			Value: "```lua\n" + text + "\n```",
+		},
+		Range: doc.rangeOf(o),
+	}
+}
+
+func (s *Server) completion(uri string, lp position) []completionItem {
+	items := []completionItem{}
+	doc := s.docs[uri]
+	if doc == nil || doc.a == nil {
+		return items
+	}
+	p := doc.aindex.fromLSP(lp)
+	prefix := wordBefore(doc.index.lines, lp)
+	for _, v := range doc.a.visible(p) {
+		name := v.Name.Value.(string)
+		if strings.HasPrefix(name, prefix) {
+			items = append(items, completionItem{
+				Label:  name,
+				Kind:   completionKindVariable,
+				Detail: "local " + doc.a.typeOf(v),
+			})
+		}
+	}
+	for _, name := range doc.a.globals() {
+		if strings.HasPrefix(name, prefix) {
+			items = append(items, completionItem{
+				Label:  name,
+				Kind:   completionKindVariable,
+				Detail: "global",
+			})
+		}
+	}
+	return items
+}
+
+// wordBefore returns the part of a name typed just before lp.
+func wordBefore(lines []string, lp position) string {
+	if lp.Line < 0 || lp.Line >= len(lines) {
+		return ""
+	}
+	line := lines[lp.Line]
+	end := 0
+	for units := 0; end < len(line) && units < lp.Character; {
+		ch, size := utf8.DecodeRuneInString(line[end:])
+		units += utf16Len(ch)
+		end += size
+	}
+	start := end
+	for start > 0 {
+		ch, size := utf8.DecodeLastRuneInString(line[:start])
+		if !isNameChar(ch) {
+			break
+		}
+		start -= size
+	}
+	return line[start:end]
+}
+
+func isNameChar(ch rune) bool {
+	return ch == '_' || '0' <= ch && ch <= '9' || 'a' <= ch && ch <= 'z' ||
+		'A' <= ch && ch <= 'Z' || ch >= 0x80 && ch != utf8.RuneError
+}
@@ -0,0 +1,420 @@
+package lsp
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/textproto"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// script is a scripted client. It queues messages for the server and
+// checks what comes back after the server has run.
+type script struct {
+	in     bytes.Buffer
+	nextID int
+}
+
+func (c *script) send(v interface{}) {
+	body, err := json.Marshal(v)
+	if err != nil {
+		panic(err)
+	}
+	c.raw(string(body))
+}
+
+func (c *script) raw(body string) {
+	fmt.Fprintf(&c.in, "Content-Length: %d\r\n\r\n%s", len(body), body)
+}
+
+// request queues a request and returns its id.
+func (c *script) request(method string, params interface{}) int {
+	c.nextID++
+	c.send(map[string]interface{}{
+		"jsonrpc": "2.0", "id": c.nextID, "method": method, "params": params,
+	})
+	return c.nextID
+}
+
+func (c *script) notify(method string, params interface{}) {
+	c.send(map[string]interface{}{
+		"jsonrpc": "2.0", "method": method, "params": params,
+	})
+}
+
+// reply is a message from the server: a response or a notification.
+type reply struct {
+	ID     json.RawMessage
+	Method string
+	Params json.RawMessage
+	Result json.RawMessage
+	Error  *responseError
+}
+
+// run runs a server on the queued messages and returns every message the
+// server wrote and the error from Run.
+func (c *script) run(t *testing.T) ([]*reply, error) {
+	var out bytes.Buffer
+	err := New(&c.in, &out).Run()
+	var replies []*reply
+	r := bufio.NewReader(&out)
+	for {
+		header, herr := textproto.NewReader(r).ReadMIMEHeader()
+		if herr == io.EOF {
+			return replies, err
+		}
+		if herr != nil {
+			t.Fatalf("bad header from server: %v", herr)
+		}
+		n, cerr := strconv.Atoi(header.Get("Content-Length"))
+		if cerr != nil {
+			t.Fatalf("bad Content-Length from server: %v", cerr)
+		}
+		body := make([]byte, n)
+		if _, rerr := io.ReadFull(r, body); rerr != nil {
+			t.Fatalf("short body from server: %v", rerr)
+		}
+		msg := new(reply)
+		if jerr := json.Unmarshal(body, msg); jerr != nil {
+			t.Fatalf("bad JSON from server: %v: %s", jerr, body)
+		}
+		replies = append(replies, msg)
+	}
+}
+
+func responseTo(t *testing.T, replies []*reply, id int) *reply {
+	for _, r := range replies {
+		if string(r.ID) == strconv.Itoa(id) {
+			return r
+		}
+	}
+	t.Fatalf("no response to request %v", id)
+	return nil
+}
+
+func result(t *testing.T, replies []*reply, id int, v interface{}) {
+	r := responseTo(t, replies, id)
+	if r.Error != nil {
+		t.Fatalf("request %v failed: %v", id, r.Error.Message)
+	}
+	if err := json.Unmarshal(r.Result, v); err != nil {
+		t.Fatalf("request %v: %v: %s", id, err, r.Result)
+	}
+}
+
+func diagnostics(t *testing.T, replies []*reply) [][]diagnostic {
+	var list [][]diagnostic
+	for _, r := range replies {
+		if r.Method == "textDocument/publishDiagnostics" {
+			var params publishDiagnosticsParams
+			if err := json.Unmarshal(r.Params, &params); err != nil {
+				t.Fatal(err)
+			}
+			list = append(list, params.Diagnostics)
+		}
+	}
+	return list
+}
+
+const uri = "file:///test.lua"
+
+func at(line, character int) map[string]interface{} {
+	return map[string]interface{}{
+		"textDocument": map[string]string{"uri": uri},
+		"position":     position{line, character},
+	}
+}
+
+func open(c *script, text string) {
+	c.notify("textDocument/didOpen", map[string]interface{}{
+		"textDocument": map[string]interface{}{
+			"uri": uri, "languageId": "lua", "version": 1, "text": text,
+		},
+	})
+}
+
+func change(c *script, text string) {
+	c.notify("textDocument/didChange", map[string]interface{}{
+		"textDocument":   map[string]interface{}{"uri": uri, "version": 2},
+		"contentChanges": []map[string]string{{"text": text}},
+	})
+}
+
+func loc(line, from, to int) location {
+	return location{uri, textRange{position{line, from}, position{line, to}}}
+}
+
+func TestSession(t *testing.T) {
+	c := new(script)
+	initialize := c.request("initialize", map[string]interface{}{})
+	c.notify("initialized", map[string]interface{}{})
+	open(c, "local a = 1\n"+
+		"local s = \"\U0001F600\" .. a\n"+
+		"if a then\n"+
+		"    local b = a\n"+
+		"    g = b\n"+
+		"end\n")
+	symbols := c.request("textDocument/documentSymbol", map[string]interface{}{
+		"textDocument": map[string]string{"uri": uri},
+	})
+	// The 'a' after the emoji is at rune 17 but UTF-16 unit 18.
+	def := c.request("textDocument/definition", at(1, 18))
+	refs := c.request("textDocument/references", map[string]interface{}{
+		"textDocument": map[string]string{"uri": uri},
+		"position":     position{0, 6},
+		"context":      map[string]bool{"includeDeclaration": true},
+	})
+	hov := c.request("textDocument/hover", at(3, 10))
+	comp := c.request("textDocument/completion", at(4, 9))
+	unknown := c.request("textDocument/formatting", at(0, 0))
+	c.raw("{not json")
+	shutdown := c.request("shutdown", nil)
+	c.notify("exit", nil)
+
+	replies, err := c.run(t)
+	if err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+
+	var init struct {
+		Capabilities map[string]interface{}
+	}
+	result(t, replies, initialize, &init)
+	if init.Capabilities["hoverProvider"] != true {
+		t.Errorf("capabilities: %v", init.Capabilities)
+	}
+
+	if d := diagnostics(t, replies); len(d) != 1 || len(d[0]) != 0 {
+		t.Errorf("diagnostics: %v", d)
+	}
+
+	var syms []documentSymbol
+	result(t, replies, symbols, &syms)
+	var names []string
+	for _, s := range syms {
+		names = append(names, s.Name+":"+s.Detail)
+	}
+	want := []string{"a:number", "s:string", "b:number"}
+	if !reflect.DeepEqual(names, want) {
+		t.Errorf("symbols %v, want %v", names, want)
+	}
+
+	var defs []location
+	result(t, replies, def, &defs)
+	if !reflect.DeepEqual(defs, []location{loc(0, 6, 7)}) {
+		t.Errorf("definition: %v", defs)
+	}
+
+	var locs []location
+	result(t, replies, refs, &locs)
+	wantLocs := []location{
+		loc(0, 6, 7), loc(1, 18, 19), loc(2, 3, 4), loc(3, 14, 15),
+	}
+	if !reflect.DeepEqual(locs, wantLocs) {
+		t.Errorf("references %v, want %v", locs, wantLocs)
+	}
+
+	var h hover
+	result(t, replies, hov, &h)
+	if !strings.Contains(h.Contents.Value, "local b: number") ||
+		h.Range != loc(3, 10, 11).Range {
+		t.Errorf("hover: %+v", h)
+	}
+
+	var items []completionItem
+	result(t, replies, comp, &items)
+	var labels []string
+	for _, item := range items {
+		labels = append(labels, item.Label)
+	}
+	// Completing 'b' in 'g = b' offers the local b and no global.
+	if !reflect.DeepEqual(labels, []string{"b"}) {
+		t.Errorf("completion: %v", labels)
+	}
+
+	if r := responseTo(t, replies, unknown); r.Error == nil ||
+		r.Error.Code != codeMethodNotFound {
+		t.Errorf("unknown method: %+v", r)
+	}
+	parseErrors := 0
+	for _, r := range replies {
+		if r.Error != nil && r.Error.Code == codeParseError &&
+			string(r.ID) == "null" {
+			parseErrors++
+		}
+	}
+	if parseErrors != 1 {
+		t.Errorf("%v parse error responses, want 1", parseErrors)
+	}
+	if r := responseTo(t, replies, shutdown); r.Error != nil {
+		t.Errorf("shutdown: %v", r.Error.Message)
+	}
+}
+
+// TestLastGoodAnalysis checks that a document that stops parsing gets a
+// diagnostic but keeps answering from the version that parsed.
+func TestLastGoodAnalysis(t *testing.T) {
+	c := new(script)
+	open(c, "local value = 1\nx = value\n")
+	change(c, "local value = 1\nx = value\nif x then\n")
+	hov := c.request("textDocument/hover", at(1, 5))
+	comp := c.request("textDocument/completion", at(2, 0))
+	change(c, "local value = 1\n")
+	c.request("shutdown", nil)
+	c.notify("exit", nil)
+
+	replies, err := c.run(t)
+	if err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+	d := diagnostics(t, replies)
+	if len(d) != 3 || len(d[0]) != 0 || len(d[1]) != 1 || len(d[2]) != 0 {
+		t.Fatalf("diagnostics: %v", d)
+	}
+	if r := d[1][0].Range.Start; r.Line != 3 || r.Character != 0 {
+		t.Errorf("error at %+v, want the end of input", r)
+	}
+
+	var h hover
+	result(t, replies, hov, &h)
+	if !strings.Contains(h.Contents.Value, "local value: number") {
+		t.Errorf("hover: %+v", h)
+	}
+	var items []completionItem
+	result(t, replies, comp, &items)
+	if len(items) != 2 || items[0].Label != "value" || items[1].Label != "x" {
+		t.Errorf("completion: %+v", items)
+	}
+}
+
+// TestLFCR checks positions after "\n\r", which the scanner takes as one
+// line break and clients as two.
+func TestLFCR(t *testing.T) {
+	c := new(script)
+	open(c, "local a = 1\n\rlocal b = a\n\r\r\nb = 2")
+	def := c.request("textDocument/definition", at(2, 10))
+	refs := c.request("textDocument/references", map[string]interface{}{
+		"textDocument": map[string]string{"uri": uri},
+		"position":     position{5, 0},
+		"context":      map[string]bool{"includeDeclaration": true},
+	})
+	// The empty client line inside the break finds nothing.
+	none := c.request("textDocument/hover", at(1, 0))
+	change(c, "local a = 1\n\r\n\rif a then")
+	c.request("shutdown", nil)
+	c.notify("exit", nil)
+
+	replies, err := c.run(t)
+	if err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+	var defs []location
+	result(t, replies, def, &defs)
+	if !reflect.DeepEqual(defs, []location{loc(0, 6, 7)}) {
+		t.Errorf("definition: %v", defs)
+	}
+	var locs []location
+	result(t, replies, refs, &locs)
+	if !reflect.DeepEqual(locs, []location{loc(2, 6, 7), loc(5, 0, 1)}) {
+		t.Errorf("references: %v", locs)
+	}
+	if r := responseTo(t, replies, none); string(r.Result) != "null" {
+		t.Errorf("hover on the empty line: %s", r.Result)
+	}
+	d := diagnostics(t, replies)
+	if len(d) != 2 || len(d[1]) != 1 {
+		t.Fatalf("diagnostics: %v", d)
+	}
+	if r := d[1][0].Range.Start; r.Line != 4 || r.Character != 9 {
+		t.Errorf("error at %+v, want 4:9", r)
+	}
+}
+
+func TestExitWithoutShutdown(t *testing.T) {
+	c := new(script)
+	c.notify("exit", nil)
+	if _, err := c.run(t); err == nil {
+		t.Error("no error")
+	}
+	c = new(script)
+	c.request("initialize", map[string]interface{}{})
+	if _, err := c.run(t); err == nil {
+		t.Error("no error when input closes")
+	}
+}
+
+func TestLineIndex(t *testing.T) {
+	tests := []struct {
+		text  string
+		lines []string
+		first []int
+	}{
+		{"", []string{""}, []int{0}},
+		{"a\nb", []string{"a", "b"}, []int{0, 1}},
+		{"a\r\nb\r", []string{"a", "b", ""}, []int{0, 1, 2}},
+		{"a\rb", []string{"a", "b"}, []int{0, 1}},
+		{"a\n\rb", []string{"a", "", "b"}, []int{0, 2}},
+		{"a\n\r\nb", []string{"a", "", "", "b"}, []int{0, 2, 3}},
+		{"a\r\r\n\n\rb", []string{"a", "", "", "", "b"},
+			[]int{0, 1, 2, 4}},
+	}
+	for _, test := range tests {
+		x := newLineIndex(test.text)
+		if !reflect.DeepEqual(x.lines, test.lines) ||
+			!reflect.DeepEqual(x.first, test.first) {
+			t.Errorf("%q: lines %q first %v, want %q %v", test.text,
+				x.lines, x.first, test.lines, test.first)
+		}
+	}
+
+	x := newLineIndex("a\U0001F600b\n\rc")
+	for _, test := range []struct {
+		p  pos
+		lp position
+	}{
+		{pos{1, 1}, position{0, 0}},
+		{pos{1, 2}, position{0, 1}},
+		{pos{1, 3}, position{0, 3}},
+		{pos{1, 4}, position{0, 4}},
+		{pos{2, 1}, position{2, 0}},
+		{pos{2, 2}, position{2, 1}},
+	} {
+		if got := x.toLSP(test.p); got != test.lp {
+			t.Errorf("toLSP(%v) = %v, want %v", test.p, got, test.lp)
+		}
+		if got := x.fromLSP(test.lp); got != test.p {
+			t.Errorf("fromLSP(%v) = %v, want %v", test.lp, got, test.p)
+		}
+	}
+}
+
+// TestParenVarArg checks that '(...)' is taken as one value.
+func TestParenVarArg(t *testing.T) {
+	c := new(script)
+	open(c, "local a, b = ...\nlocal c, d = (...)\nlocal e = (1)\n")
+	symbols := c.request("textDocument/documentSymbol", map[string]interface{}{
+		"textDocument": map[string]string{"uri": uri},
+	})
+	c.request("shutdown", nil)
+	c.notify("exit", nil)
+
+	replies, err := c.run(t)
+	if err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+	var syms []documentSymbol
+	result(t, replies, symbols, &syms)
+	var names []string
+	for _, s := range syms {
+		names = append(names, s.Name+":"+s.Detail)
+	}
+	want := []string{"a:any", "b:any", "c:any", "d:nil", "e:number"}
+	if !reflect.DeepEqual(names, want) {
+		t.Errorf("symbols %v, want %v", names, want)
+	}
+}
@@ -0,0 +1,243 @@
+package optimizer
+
+import (
+	"fmt"
+	"math"
+	"math/big"
+	"strconv"
+	"strings"
+
+	"github.com/ksco/slua/scanner"
+	"github.com/ksco/slua/syntax"
+)
+
+// Constant values are nil, bool, int64, float64 and string.
+
+// constant returns the value of exp if it is a literal.
+func constant(exp syntax.SyntaxTree) (interface{}, bool) {
+	t, ok := exp.(*syntax.Terminator)
+	if !ok {
+		return nil, false
+	}
+	switch t.Token.Category {
+	case scanner.TokenNil:
+		return nil, true
+	case scanner.TokenTrue:
+		return true, true
+	case scanner.TokenFalse:
+		return false, true
+	case scanner.TokenNumber, scanner.TokenString:
+		return t.Token.Value, true
+	}
+	return nil, false
+}
+
+// literal makes a Terminator for v at the position of at.
+func literal(v interface{}, at *scanner.Token) *syntax.Terminator {
+	t := &scanner.Token{
+		Value:  v,
+		Line:   at.Line,
+		Column: at.Column,
+		Offset: at.Offset,
+	}
+	switch v := v.(type) {
+	case nil:
+		t.Category = scanner.TokenNil
+		t.Value = scanner.TokenNil
+	case bool:
+		t.Category = scanner.TokenFalse
+		if v {
+			t.Category = scanner.TokenTrue
+		}
+		t.Value = t.Category
+	case int64, float64:
+		t.Category = scanner.TokenNumber
+	case string:
+		t.Category = scanner.TokenString
+	}
+	return &syntax.Terminator{Token: t}
+}
+
+func truthy(v interface{}) bool {
+	return v != nil && v != false
+}
+
+func unary(op string, v interface{}) (interface{}, bool) {
+	switch op {
+	case scanner.TokenNot:
+		return !truthy(v), true
+	case scanner.TokenSub:
+		switch v := v.(type) {
+		case int64:
+			return -v, true
+		case float64:
+			return -v, true
+		}
+	case scanner.TokenLen:
+		if s, ok := v.(string); ok {
+			return int64(len(s)), true
+		}
+	}
+	return nil, false
+}
+
+func binary(op string, a, b interface{}) (interface{}, bool) {
+	switch op {
+	case scanner.TokenAdd, scanner.TokenSub, scanner.TokenMul,
+		scanner.TokenDiv:
+		return arith(op, a, b)
+	case scanner.TokenConcat:
+		s, ok := toString(a)
+		t, ok2 := toString(b)
+		if !ok || !ok2 {
+			return nil, false
+		}
+		return s + t, true
+	case scanner.TokenEqual:
+		return equal(a, b), true
+	case scanner.TokenNotEqual:
+		return !equal(a, b), true
+	case scanner.TokenLess:
+		return less(a, b, false)
+	case scanner.TokenLessEqual:
+		return less(a, b, true)
+	case scanner.TokenGreater:
+		return less(b, a, false)
+	case scanner.TokenGreaterEqual:
+		return less(b, a, true)
+	}
+	return nil, false
+}
+
+// arith folds an arithmetic operator over two numbers. Strings would be
+// converted at run time, but are left alone here.
+func arith(op string, a, b interface{}) (interface{}, bool) {
+	x, xok := a.(int64)
+	y, yok := b.(int64)
+	if xok && yok && op != scanner.TokenDiv {
+		switch op {
+		case scanner.TokenAdd:
+			return x + y, true
+		case scanner.TokenSub:
+			return x - y, true
+		default:
+			return x * y, true
+		}
+	}
+	f, ok := toFloat(a)
+	g, ok2 := toFloat(b)
+	if !ok || !ok2 {
+		return nil, false
+	}
+	var r float64
+	switch op {
+	case scanner.TokenAdd:
+		r = f + g
+	case scanner.TokenSub:
+		r = f - g
+	case scanner.TokenMul:
+		r = f * g
+	default:
+		if g == 0 {
+			return nil, false
+		}
+		r = f / g
+	}
+	if math.IsInf(r, 0) || math.IsNaN(r) {
+		return nil, false
+	}
+	return r, true
+}
+
+func toFloat(v interface{}) (float64, bool) {
+	switch v := v.(type) {
+	case int64:
+		return float64(v), true
+	case float64:
+		return v, true
+	}
+	return 0, false
+}
+
+// toString converts a string or a number the way '..' does.
+func toString(v interface{}) (string, bool) {
+	switch v := v.(type) {
+	case string:
+		return v, true
+	case int64:
+		return strconv.FormatInt(v, 10), true
+	case float64:
+		if math.IsInf(v, 0) || math.IsNaN(v) {
+			return "", false
+		}
+		s := fmt.Sprintf("%.14g", v)
+		if !strings.ContainsAny(s, ".e") {
+			s += ".0"
+		}
+		return s, true
+	}
+	return "", false
+}
+
+// compare compares two numbers exactly, even an integer with a float that
+// cannot hold it. It returns false if either is nan.
+func compare(a, b interface{}) (int, bool) {
+	x, xok := a.(int64)
+	y, yok := b.(int64)
+	if xok && yok {
+		switch {
+		case x < y:
+			return -1, true
+		case x > y:
+			return 1, true
+		}
+		return 0, true
+	}
+	var bf [2]*big.Float
+	for i, v := range []interface{}{a, b} {
+		switch v := v.(type) {
+		case int64:
+			bf[i] = new(big.Float).SetInt64(v)
+		case float64:
+			if math.IsNaN(v) {
+				return 0, false
+			}
+			bf[i] = big.NewFloat(v)
+		}
+	}
+	return bf[0].Cmp(bf[1]), true
+}
+
+func isNumber(v interface{}) bool {
+	switch v.(type) {
+	case int64, float64:
+		return true
+	}
+	return false
+}
+
+func equal(a, b interface{}) bool {
+	if isNumber(a) && isNumber(b) {
+		c, ok := compare(a, b)
+		return ok && c == 0
+	}
+	return a == b
+}
+
+// less folds a < b, or a <= b if orEqual is set. Only two numbers or two
+// strings can be compared.
+func less(a, b interface{}, orEqual bool) (interface{}, bool) {
+	if isNumber(a) && isNumber(b) {
+		c, ok := compare(a, b)
+		return ok && (c < 0 || orEqual && c == 0), true
+	}
+	s, ok := a.(string)
+	t, ok2 := b.(string)
+	if !ok || !ok2 {
+		return nil, false
+	}
+	if orEqual {
+		return s <= t, true
+	}
+	return s < t, true
+}
@@ -0,0 +1,163 @@
+// Package optimizer simplifies syntax trees without changing what they mean.
+// It folds operators whose operands are literals and drops the branches of
+// 'if' statements whose conditions are constant.
+package optimizer
+
+import (
+	"fmt"
+
+	"github.com/ksco/slua/scanner"
+	"github.com/ksco/slua/syntax"
+)
+
+// Optimize rewrites tree, a chunk, a block or an expression, in place and
+// returns what replaces it. Folding follows Lua 5.3: integers stay integers
+// and wrap around, '/' always gives a float, and anything that would raise
+// an error at run time, like a division by zero, a comparison of a number
+// with a string or arithmetic on a string, is left for run time. Results
+// that have no literal, like inf and nan, are not folded either.
+//
+// Other goroutines must not be using tree while it is rewritten.
+func Optimize(tree syntax.SyntaxTree) syntax.SyntaxTree {
+	switch t := tree.(type) {
+	case *syntax.Chunk:
+		t.Block = Optimize(t.Block)
+	case *syntax.Block:
+		stmts := t.Stmts[:0]
+		for _, stmt := range t.Stmts {
+			if stmt = statement(stmt); stmt != nil {
+				stmts = append(stmts, stmt)
+			}
+		}
+		t.Stmts = stmts
+	default:
+		return expression(tree)
+	}
+	return tree
+}
+
+// statement optimizes stmt and returns what replaces it, or nil if it can
+// go.
+func statement(stmt syntax.SyntaxTree) syntax.SyntaxTree {
+	switch t := stmt.(type) {
+	case *syntax.DoStatement:
+		t.Block = Optimize(t.Block)
+	case *syntax.WhileStatement:
+		t.Exp = expression(t.Exp)
+		t.Block = Optimize(t.Block)
+	case *syntax.IfStatement:
+		t.Exp = expression(t.Exp)
+		t.TrueBranch = Optimize(t.TrueBranch)
+		t.FalseBranch = elseBranch(t.FalseBranch)
+		v, ok := constant(t.Exp)
+		if !ok {
+			return t
+		}
+		// The branch that is left keeps its own block, so that its
+		// locals stay out of the enclosing one.
+		if truthy(v) {
+			return &syntax.DoStatement{Block: t.TrueBranch}
+		}
+		switch f := t.FalseBranch.(type) {
+		case *syntax.ElseifStatement:
+			return &syntax.IfStatement{
+				Exp:         f.Exp,
+				TrueBranch:  f.TrueBranch,
+				FalseBranch: f.FalseBranch,
+			}
+		case *syntax.ElseStatement:
+			return &syntax.DoStatement{Block: f.Block}
+		default:
+			return nil
+		}
+	case *syntax.LocalNameListStatement:
+		if t.ExpList != nil {
+			t.ExpList = expression(t.ExpList)
+		}
+	case *syntax.AssignmentStatement:
+		t.ExpList = expression(t.ExpList)
+	}
+	return stmt
+}
+
+// elseBranch optimizes the false branch of an 'if' or 'elseif', leaving no
+// 'elseif' with a constant condition in the chain.
+func elseBranch(branch syntax.SyntaxTree) syntax.SyntaxTree {
+	switch t := branch.(type) {
+	case *syntax.ElseifStatement:
+		t.Exp = expression(t.Exp)
+		t.TrueBranch = Optimize(t.TrueBranch)
+		t.FalseBranch = elseBranch(t.FalseBranch)
+		v, ok := constant(t.Exp)
+		if !ok {
+			return t
+		}
+		if truthy(v) {
+			return &syntax.ElseStatement{Block: t.TrueBranch}
+		}
+		return t.FalseBranch
+	case *syntax.ElseStatement:
+		t.Block = Optimize(t.Block)
+	}
+	return branch
+}
+
+func expression(exp syntax.SyntaxTree) syntax.SyntaxTree {
+	switch t := exp.(type) {
+	case *syntax.ExpressionList:
+		for i, e := range t.ExpList {
+			t.ExpList[i] = expression(e)
+		}
+	case *syntax.UnaryExpression:
+		t.Exp = expression(t.Exp)
+		if v, ok := constant(t.Exp); ok {
+			if r, ok := unary(t.OpToken.Category, v); ok {
+				return literal(r, t.OpToken)
+			}
+		}
+	case *syntax.BinaryExpression:
+		t.Left = expression(t.Left)
+		t.Right = expression(t.Right)
+		return binaryExpression(t)
+	case *syntax.ParenExpression:
+		// A literal needs no parentheses, but '(...)' keeps them.
+		t.Exp = expression(t.Exp)
+		if v, ok := constant(t.Exp); ok {
+			return literal(v, t.LeftParen)
+		}
+	case *syntax.Terminator:
+	default:
+		panic(fmt.Sprintf(
+			"slua/optimizer internal error: unexpect expression %T", exp))
+	}
+	return exp
+}
+
+func binaryExpression(t *syntax.BinaryExpression) syntax.SyntaxTree {
+	a, ok := constant(t.Left)
+	if !ok {
+		return t
+	}
+	switch t.OpToken.Category {
+	case scanner.TokenAnd, scanner.TokenOr:
+		// The right operand need not be constant, but 'true and ...'
+		// gives one value where a bare '...' gives them all. '(...)'
+		// gives one value too and can stand alone.
+		if truthy(a) == (t.OpToken.Category == scanner.TokenAnd) {
+			if syntax.IsVarArg(t.Right) {
+				return t
+			}
+			return t.Right
+		}
+		return t.Left
+	}
+	b, ok := constant(t.Right)
+	if !ok {
+		return t
+	}
+	if r, ok := binary(t.OpToken.Category, a, b); ok {
+		// A folded literal is placed where the expression started.
+		return literal(r, syntax.FirstToken(t))
+	}
+	return t
+}
@@ -0,0 +1,249 @@
+package optimizer_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/ksco/slua/optimizer"
+	"github.com/ksco/slua/parser"
+	"github.com/ksco/slua/printer"
+	"github.com/ksco/slua/scanner"
+	"github.com/ksco/slua/syntax"
+)
+
+func optimize(src string) string {
+	tree := parser.New(scanner.New(strings.NewReader(src))).Parse()
+	var b strings.Builder
+	printer.Fprint(&b, optimizer.Optimize(tree))
+	return b.String()
+}
+
+// Each test is an expression and what it folds to.
+var expressionTests = []struct {
+	exp, want string
+}{
+	// Integers stay integers, floats stay floats.
+	{"2 * 3 + 1", "7"},
+	{"1 + 2.0", "3.0"},
+	{"3 - 2.5", "0.5"},
+	{"1.5 * 2", "3.0"},
+	{"7 / 2", "3.5"},
+	{"6 / 3", "2.0"},
+	{"-5", "(-5)"},
+	{"- -5", "5"},
+
+	// Integer arithmetic wraps around.
+	{"9223372036854775807 + 1", "(-9223372036854775807 - 1)"},
+	{"-9223372036854775807 - 2", "9223372036854775807"},
+	{"4611686018427387904 * 2", "(-9223372036854775807 - 1)"},
+	{"-(-9223372036854775807 - 1)", "(-9223372036854775807 - 1)"},
+	// A numeral too big for an integer is a float.
+	{"9223372036854775808 + 0", "9223372036854776000.0"},
+
+	// Division by zero and results without a literal are left alone.
+	{"1 / 0", "1 / 0"},
+	{"0 / 0", "0 / 0"},
+	{"1 / 0.0", "1 / 0.0"},
+	{"-1 / 0", "(-1) / 0"},
+	{"0.0 / 1", "0.0"},
+
+	// Negative zero keeps its sign.
+	{"-0.0", "(-0.0)"},
+	{"-(0.0)", "(-0.0)"},
+	{"-0", "0"},
+	{"0.0 * -1", "(-0.0)"},
+
+	// Arithmetic on strings is converted at run time, not here.
+	{"\"10\" + 1", "\"10\" + 1"},
+	{"-\"1\"", "-\"1\""},
+	{"1 + nil", "1 + nil"},
+
+	{"#\"abc\"", "3"},
+	{"#\"\"", "0"},
+	{"#1", "#1"},
+
+	{"\"a\" .. \"b\"", "\"ab\""},
+	{"\"n\" .. 1", "\"n1\""},
+	{"1 .. 2", "\"12\""},
+	{"\"x\" .. 2.0", "\"x2.0\""},
+	{"\"x\" .. 0.1", "\"x0.1\""},
+	{"\"x\" .. 100000000000000.0", "\"x1e+14\""},
+	{"\"x\" .. 0.1 + 0.2", "\"x0.3\""},
+	{"\"x\" .. nil", "\"x\" .. nil"},
+	{"\"x\" .. true", "\"x\" .. true"},
+
+	// Integers and floats compare by value.
+	{"3 == 3.0", "true"},
+	{"3 ~= 3.0", "false"},
+	{"1 == \"1\"", "false"},
+	{"nil == false", "false"},
+	{"nil == nil", "true"},
+	{"\"a\" == \"a\"", "true"},
+	{"1 < 2.5", "true"},
+	{"2 <= 2.0", "true"},
+	{"3 > 2", "true"},
+	{"2 >= 3", "false"},
+	{"9007199254740993 > 9007199254740992.0", "true"},
+	{"9007199254740993 == 9007199254740992.0", "false"},
+	{"\"a\" < \"b\"", "true"},
+	{"\"b\" <= \"a\"", "false"},
+	{"\"Z\" < \"a\"", "true"},
+	{"1 < \"2\"", "1 < \"2\""},
+	{"nil < 1", "nil < 1"},
+	{"true < false", "true < false"},
+
+	{"not nil", "true"},
+	{"not false", "true"},
+	{"not 0", "false"},
+	{"not \"\"", "false"},
+	{"not not x", "not not x"},
+
+	// 'and' and 'or' fold on a constant left operand, whatever the
+	// right one is.
+	{"true and x", "x"},
+	{"1 and 2", "2"},
+	{"false and x", "false"},
+	{"nil and x", "nil"},
+	{"nil or x", "x"},
+	{"false or nil", "nil"},
+	{"0 or x", "0"},
+	{"x and true", "x and true"},
+	{"x or 1", "x or 1"},
+	// A bare '...' would give every value, not just the first.
+	{"true and ...", "true and ..."},
+	{"nil or ...", "nil or ..."},
+	{"false and ...", "false"},
+	{"1 or ...", "1"},
+	// '(...)' is one value already.
+	{"true and (...)", "(...)"},
+	{"nil or (...)", "(...)"},
+	{"(...)", "(...)"},
+
+	// Parentheses stay around what is not folded.
+	{"(x)", "(x)"},
+	{"((1 + 2))", "3"},
+	{"(x + 1) * 2", "(x + 1) * 2"},
+
+	// Folding works from the inside out.
+	{"(1 + 2) * (3 + x)", "3 * (3 + x)"},
+	{"not (1 < 2) or x", "x"},
+	{"x + 1 + 2", "x + 1 + 2"},
+	{"\"a\" .. #\"bc\" .. -1", "\"a2-1\""},
+}
+
+func TestExpressions(t *testing.T) {
+	for _, test := range expressionTests {
+		got := optimize("v = " + test.exp)
+		if want := "v = " + test.want + "\n"; got != want {
+			t.Errorf("%v: got %q, want %q", test.exp, got, want)
+		}
+	}
+}
+
+var statementTests = []struct {
+	name, src, want string
+}{
+	{
+		"true if",
+		"if 1 < 2 then local a = 1 end",
+		"do\n    local a = 1\nend\n",
+	},
+	{
+		"false if",
+		"x = 1 if false then x = 2 end y = 1",
+		"x = 1\ny = 1\n",
+	},
+	{
+		"false if with else",
+		"if nil then x = 1 else x = 2 end",
+		"do\n    x = 2\nend\n",
+	},
+	{
+		"false if with elseif",
+		"if false then x = 1 elseif y then x = 2 else x = 3 end",
+		"if y then\n    x = 2\nelse\n    x = 3\nend\n",
+	},
+	{
+		"true elseif",
+		"if y then x = 1 elseif true then x = 2 elseif z then x = 3 " +
+			"else x = 4 end",
+		"if y then\n    x = 1\nelse\n    x = 2\nend\n",
+	},
+	{
+		"false elseif",
+		"if y then x = 1 elseif 1 > 2 then x = 2 elseif z then x = 3 end",
+		"if y then\n    x = 1\nelseif z then\n    x = 3\nend\n",
+	},
+	{
+		"false elseif at the end",
+		"if y then x = 1 elseif false then x = 2 end",
+		"if y then\n    x = 1\nend\n",
+	},
+	{
+		"chain that collapses",
+		"if false then x = 1 elseif nil then x = 2 elseif 1 then x = 3 " +
+			"else x = 4 end",
+		"do\n    x = 3\nend\n",
+	},
+	{
+		"chain with nothing left",
+		"if false then x = 1 elseif nil then x = 2 end",
+		"",
+	},
+	{
+		"nested",
+		"while x do if not false then if 2 < 1 then y = 1 end end end",
+		"while x do\n    do\n    end\nend\n",
+	},
+	{
+		"non-constant condition is folded but kept",
+		"if x == 1 + 1 then y = 2 * 2 end",
+		"if x == 2 then\n    y = 4\nend\n",
+	},
+	{
+		"while is left alone",
+		"while 1 > 2 do x = 1 end",
+		"while false do\n    x = 1\nend\n",
+	},
+	{
+		"local lists",
+		"local a, b = 1 + 1, ... local c",
+		"local a, b = 2, ...\nlocal c\n",
+	},
+}
+
+func TestStatements(t *testing.T) {
+	for _, test := range statementTests {
+		if got := optimize(test.src); got != test.want {
+			t.Errorf("%v: got\n%v\nwant\n%v", test.name, got, test.want)
+		}
+	}
+}
+
+// TestPositions checks that a folded literal sits where the expression it
+// replaces started.
+func TestPositions(t *testing.T) {
+	tree := parser.New(scanner.New(strings.NewReader(
+		"local a =\n  -(1 + 2) * 3, 4 .. \"x\""))).Parse()
+	tree = optimizer.Optimize(tree)
+	stmt := tree.(*syntax.Chunk).Block.(*syntax.Block).Stmts[0]
+	exps := stmt.(*syntax.LocalNameListStatement).ExpList
+	want := []struct {
+		value        interface{}
+		line, column int
+		offset       int
+	}{
+		{int64(-9), 2, 3, 12},
+		{"4x", 2, 17, 26},
+	}
+	for i, exp := range exps.(*syntax.ExpressionList).ExpList {
+		tok := exp.(*syntax.Terminator).Token
+		w := want[i]
+		if tok.Value != w.value || tok.Line != w.line ||
+			tok.Column != w.column || tok.Offset != w.offset {
+			t.Errorf("literal %v is %#v at %v:%v offset %v, want %#v at "+
+				"%v:%v offset %v", i, tok.Value, tok.Line, tok.Column,
+				tok.Offset, w.value, w.line, w.column, w.offset)
+		}
+	}
+}
@@ -13,8 +13,24 @@ type Error struct {
 }
 
 func (e *Error) Error() string {
-	return fmt.Sprintf("%v:%v:%v: '%v' %v", e.module, e.token.Line,
-		e.token.Column, e.token.String(), e.str)
+	return fmt.Sprintf("%v:%v:%v: %v", e.module, e.token.Line,
+		e.token.Column, e.Message())
+}
+
+// Position returns the line and column of the token the error was found at.
+func (e *Error) Position() (line, column int) {
+	return e.token.Line, e.token.Column
+}
+
+// Message returns the error without its position.
+func (e *Error) Message() string {
+	return fmt.Sprintf("'%v' %v", e.token.String(), e.str)
+}
+
+// Incomplete reports whether the error was found at the end of the input,
+// so that more input could make it valid.
+func (e *Error) Incomplete() bool {
+	return e.token.Category == scanner.TokenEOF
 }
 
 func assert(cond bool, msg string) {
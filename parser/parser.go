@@ -5,6 +5,9 @@ import (
 	"github.com/ksco/slua/syntax"
 )
 
+// A Parser, like the Scanner it reads from, must not be used from more than
+// one goroutine at a time. Separate parsers share no state and can run in
+// parallel.
 type Parser struct {
 	s              *scanner.Scanner
 	module         string
@@ -25,6 +28,24 @@ func (p *Parser) Parse() syntax.SyntaxTree {
 	return p.parseChunk()
 }
 
+// ParseChunk parses the chunk s reads. Unlike Parse, it returns scanner and
+// parser errors instead of panicking with them.
+func ParseChunk(s *scanner.Scanner) (tree syntax.SyntaxTree, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			switch e := r.(type) {
+			case *scanner.Error:
+				err = e
+			case *Error:
+				err = e
+			default:
+				panic(r)
+			}
+		}
+	}()
+	return New(s).Parse(), nil
+}
+
 const (
 	prefixExpTypeNormal = iota
 	prefixExpTypeVar
@@ -75,15 +96,15 @@ func (p *Parser) parseStatement() syntax.SyntaxTree {
 	case ";":
 		p.nextToken()
 	case scanner.TokenDo:
-		p.parseDoStatement()
+		return p.parseDoStatement()
 	case scanner.TokenWhile:
-		p.parseWhileStatement()
+		return p.parseWhileStatement()
 	case scanner.TokenIf:
-		p.parseIfStatement()
+		return p.parseIfStatement()
 	case scanner.TokenLocal:
-		p.parseLocalStatement()
+		return p.parseLocalStatement()
 	default:
-		p.parseOtherStatement()
+		return p.parseOtherStatement()
 	}
 
 	return nil
@@ -317,9 +338,9 @@ func (p *Parser) parseExpImpl(left syntax.SyntaxTree, oprand *scanner.Token,
 	if p.lookAheadToken.Category == scanner.TokenSub ||
 		p.lookAheadToken.Category == scanner.TokenLen ||
 		p.lookAheadToken.Category == scanner.TokenNot {
-		p.nextToken()
+		opToken := p.nextToken().Clone()
 		exp = &syntax.UnaryExpression{
-			OpToken: p.currentToken,
+			OpToken: opToken,
 			Exp:     p.parseExpImpl(nil, scanner.NewToken(), 90),
 		}
 	} else if isMainExp(p.lookAheadToken) {
@@ -365,7 +386,7 @@ func (p *Parser) parseMainExp() syntax.SyntaxTree {
 	var exp syntax.SyntaxTree
 	switch p.lookAhead().Category {
 	case scanner.TokenNil, scanner.TokenFalse, scanner.TokenTrue,
-		scanner.TokenNumber, scanner.TokenString:
+		scanner.TokenNumber, scanner.TokenString, scanner.TokenVarArg:
 		exp = &syntax.Terminator{Token: p.nextToken().Clone()}
 	case scanner.TokenID, scanner.TokenLeftParen:
 		exp, _ = p.parsePrefixExp()
@@ -392,7 +413,8 @@ func (p *Parser) parsePrefixExp() (syntax.SyntaxTree, int) {
 	var exp syntax.SyntaxTree
 	var expType int
 	if p.currentToken.Category == scanner.TokenLeftParen {
-		exp = p.parseExp()
+		leftParen := p.currentToken.Clone()
+		exp = &syntax.ParenExpression{Exp: p.parseExp(), LeftParen: leftParen}
 		if p.nextToken().Category != scanner.TokenRightParen {
 			panic(&Error{
 				module: p.module,
@@ -414,6 +436,7 @@ func isMainExp(token *scanner.Token) bool {
 		token.Category == scanner.TokenTrue ||
 		token.Category == scanner.TokenNumber ||
 		token.Category == scanner.TokenString ||
+		token.Category == scanner.TokenVarArg ||
 		token.Category == scanner.TokenID ||
 		token.Category == scanner.TokenLeftParen
 }
@@ -0,0 +1,258 @@
+package printer
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/ksco/slua/scanner"
+	"github.com/ksco/slua/syntax"
+)
+
+const indent = "    "
+
+type printer struct {
+	w     io.Writer
+	depth int
+}
+
+// Fprint writes tree to w as Lua source. Parentheses are added wherever the
+// tree's shape differs from what operator priorities alone would give, so
+// parsing the output gives back the same tree.
+func Fprint(w io.Writer, tree syntax.SyntaxTree) {
+	p := &printer{w: w}
+	p.stmt(tree)
+}
+
+func (p *printer) line(format string, a ...interface{}) {
+	fmt.Fprint(p.w, strings.Repeat(indent, p.depth))
+	fmt.Fprintf(p.w, format, a...)
+	fmt.Fprintln(p.w)
+}
+
+func (p *printer) block(tree syntax.SyntaxTree) {
+	p.depth++
+	p.stmt(tree)
+	p.depth--
+}
+
+func (p *printer) stmt(tree syntax.SyntaxTree) {
+	switch t := tree.(type) {
+	case nil:
+	case *syntax.Chunk:
+		p.stmt(t.Block)
+	case *syntax.Block:
+		for _, stmt := range t.Stmts {
+			p.stmt(stmt)
+		}
+	case *syntax.DoStatement:
+		p.line("do")
+		p.block(t.Block)
+		p.line("end")
+	case *syntax.WhileStatement:
+		p.line("while %v do", exp(t.Exp))
+		p.block(t.Block)
+		p.line("end")
+	case *syntax.IfStatement:
+		p.line("if %v then", exp(t.Exp))
+		p.block(t.TrueBranch)
+		p.falseBranch(t.FalseBranch)
+	case *syntax.LocalNameListStatement:
+		var names []string
+		for _, name := range t.NameList.(*syntax.NameList).Names {
+			names = append(names, name.Value.(string))
+		}
+		if t.ExpList == nil {
+			p.line("local %v", strings.Join(names, ", "))
+		} else {
+			p.line("local %v = %v", strings.Join(names, ", "), exp(t.ExpList))
+		}
+	case *syntax.AssignmentStatement:
+		p.line("%v = %v", exp(t.VarList), exp(t.ExpList))
+	default:
+		panic(fmt.Sprintf("slua/printer internal error: unexpect statement %T",
+			tree))
+	}
+}
+
+func (p *printer) falseBranch(tree syntax.SyntaxTree) {
+	switch t := tree.(type) {
+	case nil:
+		p.line("end")
+	case *syntax.ElseifStatement:
+		p.line("elseif %v then", exp(t.Exp))
+		p.block(t.TrueBranch)
+		p.falseBranch(t.FalseBranch)
+	case *syntax.ElseStatement:
+		p.line("else")
+		p.block(t.Block)
+		p.line("end")
+	default:
+		panic(fmt.Sprintf("slua/printer internal error: unexpect branch %T",
+			tree))
+	}
+}
+
+const unaryPriority = 90
+
+// priority mirrors the parser's operator priorities. All binary operators
+// are parsed left associative.
+func priority(tree syntax.SyntaxTree) int {
+	switch t := tree.(type) {
+	case *syntax.BinaryExpression:
+		switch t.OpToken.Category {
+		case scanner.TokenDiv, scanner.TokenMul:
+			return 80
+		case scanner.TokenAdd, scanner.TokenSub:
+			return 70
+		case scanner.TokenConcat:
+			return 60
+		case scanner.TokenAnd:
+			return 40
+		case scanner.TokenOr:
+			return 30
+		default:
+			return 50
+		}
+	case *syntax.UnaryExpression:
+		return unaryPriority
+	default:
+		return 100
+	}
+}
+
+func paren(s string, cond bool) string {
+	if cond {
+		return "(" + s + ")"
+	}
+	return s
+}
+
+func exp(tree syntax.SyntaxTree) string {
+	switch t := tree.(type) {
+	case *syntax.ExpressionList:
+		var exps []string
+		for _, e := range t.ExpList {
+			exps = append(exps, exp(e))
+		}
+		return strings.Join(exps, ", ")
+	case *syntax.VarList:
+		var vars []string
+		for _, v := range t.VarList {
+			vars = append(vars, exp(v))
+		}
+		return strings.Join(vars, ", ")
+	case *syntax.BinaryExpression:
+		prio := priority(t)
+		left := paren(exp(t.Left), priority(t.Left) < prio)
+		right := paren(exp(t.Right), priority(t.Right) <= prio)
+		return left + " " + t.OpToken.Category + " " + right
+	case *syntax.UnaryExpression:
+		operand := paren(exp(t.Exp), priority(t.Exp) < unaryPriority)
+		switch {
+		case t.OpToken.Category == scanner.TokenNot:
+			return "not " + operand
+		case strings.HasPrefix(operand, "-"):
+			// Keep '- -x' from turning into a comment.
+			return t.OpToken.Category + " " + operand
+		default:
+			return t.OpToken.Category + operand
+		}
+	case *syntax.ParenExpression:
+		return "(" + exp(t.Exp) + ")"
+	case *syntax.Terminator:
+		return terminator(t.Token)
+	default:
+		panic(fmt.Sprintf("slua/printer internal error: unexpect expression %T",
+			tree))
+	}
+}
+
+func terminator(t *scanner.Token) string {
+	switch t.Category {
+	case scanner.TokenNumber:
+		return number(t.Value)
+	case scanner.TokenString:
+		return quote(t.Value.(string))
+	case scanner.TokenID:
+		return t.Value.(string)
+	default:
+		return t.Category
+	}
+}
+
+// number formats n with the digits and '.' the scanner reads, keeping a
+// '.' in floats so they read back as floats. Values that have no such
+// literal are written as expressions.
+func number(n interface{}) string {
+	switch n := n.(type) {
+	case int64:
+		switch {
+		case n == math.MinInt64:
+			return "(-9223372036854775807 - 1)"
+		case n < 0:
+			return "(-" + strconv.FormatInt(-n, 10) + ")"
+		default:
+			return strconv.FormatInt(n, 10)
+		}
+	case float64:
+		switch {
+		case math.IsInf(n, 1):
+			return "(1/0)"
+		case math.IsInf(n, -1):
+			return "(-1/0)"
+		case math.IsNaN(n):
+			return "(0/0)"
+		case math.Signbit(n):
+			return "(-" + number(-n) + ")"
+		}
+		s := strconv.FormatFloat(n, 'f', -1, 64)
+		if !strings.Contains(s, ".") {
+			s += ".0"
+		}
+		return s
+	default:
+		panic(fmt.Sprintf("slua/printer internal error: unexpect number %T",
+			n))
+	}
+}
+
+// quote writes s as a string literal. Bytes that are not valid UTF-8 and
+// control characters without a letter escape are written as '\xXX'.
+func quote(s string) string {
+	var b strings.Builder
+	b.WriteByte('"')
+	for i := 0; i < len(s); {
+		ch, size := utf8.DecodeRuneInString(s[i:])
+		switch {
+		case ch == '\a':
+			b.WriteString(`\a`)
+		case ch == '\b':
+			b.WriteString(`\b`)
+		case ch == '\f':
+			b.WriteString(`\f`)
+		case ch == '\n':
+			b.WriteString(`\n`)
+		case ch == '\r':
+			b.WriteString(`\r`)
+		case ch == '\t':
+			b.WriteString(`\t`)
+		case ch == '\v':
+			b.WriteString(`\v`)
+		case ch == '\\':
+			b.WriteString(`\\`)
+		case ch == '"':
+			b.WriteString(`\"`)
+		case ch == utf8.RuneError && size == 1, ch < ' ', ch == 0x7F:
+			fmt.Fprintf(&b, `\x%02X`, s[i])
+		default:
+			b.WriteString(s[i : i+size])
+		}
+		i += size
+	}
+	b.WriteByte('"')
+	return b.String()
+}
@@ -0,0 +1,35 @@
+package printer
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/ksco/slua/scanner"
+)
+
+func TestQuote(t *testing.T) {
+	tests := []struct {
+		s, want string
+	}{
+		{"", `""`},
+		{"abc", `"abc"`},
+		{"a\"b\\c", `"a\"b\\c"`},
+		{"'", `"'"`},
+		{"\a\b\f\n\r\t\v", `"\a\b\f\n\r\t\v"`},
+		{"\x00\x01\x1F", `"\x00\x01\x1F"`},
+		{"\x7F", `"\x7F"`},
+		{"\xFF\xC3", `"\xFF\xC3"`},
+		{"é\U0001F600", "\"é\U0001F600\""},
+		{"\u0080", "\"\u0080\""},
+	}
+	for _, test := range tests {
+		got := quote(test.s)
+		if got != test.want {
+			t.Errorf("quote(%q) = %v, want %v", test.s, got, test.want)
+		}
+		tok := scanner.New(strings.NewReader(got)).Scan()
+		if tok.Value != test.s {
+			t.Errorf("%v scans as %q, want %q", got, tok.Value, test.s)
+		}
+	}
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/ksco/slua/parser"
+	"github.com/ksco/slua/scanner"
+)
+
+const (
+	prompt         = "> "
+	continuePrompt = ">> "
+)
+
+type incompleteError interface {
+	Incomplete() bool
+}
+
+// repl reads chunks from in line by line. A chunk that ends before it is
+// complete, like an 'if' without its 'end', is continued on the next line.
+func repl(in io.Reader, out io.Writer) {
+	lines := bufio.NewScanner(in)
+	var chunk []string
+	fmt.Fprint(out, prompt)
+	for lines.Scan() {
+		chunk = append(chunk, lines.Text())
+		src := strings.NewReader(strings.Join(chunk, "\n"))
+		_, err := parser.ParseChunk(scanner.New(src))
+		if e, ok := err.(incompleteError); ok && e.Incomplete() {
+			fmt.Fprint(out, continuePrompt)
+			continue
+		}
+		if err != nil {
+			fmt.Fprintln(out, err)
+		}
+		chunk = chunk[:0]
+		fmt.Fprint(out, prompt)
+	}
+	fmt.Fprintln(out)
+}
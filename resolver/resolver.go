@@ -0,0 +1,188 @@
+package resolver
+
+import (
+	"github.com/ksco/slua/scanner"
+	"github.com/ksco/slua/syntax"
+)
+
+const envName = "_ENV"
+
+// Kind tells how a name is bound.
+type Kind int
+
+const (
+	Global Kind = iota
+	Local
+)
+
+func (k Kind) String() string {
+	switch k {
+	case Local:
+		return "local"
+	default:
+		return "global"
+	}
+}
+
+// Variable is a local variable introduced by a 'local' statement. Refs are
+// the names that read it and Writes the names that assign to it.
+type Variable struct {
+	Name   *scanner.Token
+	Scope  *Scope
+	Refs   []*syntax.Terminator
+	Writes []*syntax.Terminator
+}
+
+// Scope holds the locals declared in one block, in declaration order.
+type Scope struct {
+	Parent   *Scope
+	Block    syntax.SyntaxTree
+	Vars     []*Variable
+	Children []*Scope
+}
+
+// Lookup finds the innermost local named name that is visible at the end of
+// scope s, or nil if name is global there.
+func (s *Scope) Lookup(name string) *Variable {
+	for ; s != nil; s = s.Parent {
+		for i := len(s.Vars) - 1; i >= 0; i-- {
+			if s.Vars[i].Name.Value == name {
+				return s.Vars[i]
+			}
+		}
+	}
+	return nil
+}
+
+// Resolution is what a Terminator name refers to. For a Global, Var is nil
+// and Env is the local '_ENV' the access goes through, or nil when it is the
+// chunk's own environment.
+type Resolution struct {
+	Kind Kind
+	Var  *Variable
+	Env  *Variable
+}
+
+// Info is the result of resolving a chunk. Decls maps the name tokens of
+// 'local' statements to the locals they declare.
+type Info struct {
+	Scope *Scope
+	Names map[*syntax.Terminator]*Resolution
+	Decls map[*scanner.Token]*Variable
+}
+
+type resolver struct {
+	info  *Info
+	scope *Scope
+}
+
+// Resolve builds the scopes of tree and resolves every name in it.
+func Resolve(tree syntax.SyntaxTree) *Info {
+	r := &resolver{
+		info: &Info{
+			Names: make(map[*syntax.Terminator]*Resolution),
+			Decls: make(map[*scanner.Token]*Variable),
+		},
+	}
+	r.resolve(tree)
+	return r.info
+}
+
+func (r *resolver) openScope(block syntax.SyntaxTree) {
+	s := &Scope{Parent: r.scope, Block: block}
+	if r.scope != nil {
+		r.scope.Children = append(r.scope.Children, s)
+	} else {
+		r.info.Scope = s
+	}
+	r.scope = s
+}
+
+func (r *resolver) closeScope() {
+	r.scope = r.scope.Parent
+}
+
+func (r *resolver) block(block syntax.SyntaxTree) {
+	r.openScope(block)
+	r.resolve(block)
+	r.closeScope()
+}
+
+func (r *resolver) resolve(tree syntax.SyntaxTree) {
+	switch t := tree.(type) {
+	case nil:
+	case *syntax.Chunk:
+		r.block(t.Block)
+	case *syntax.Block:
+		for _, stmt := range t.Stmts {
+			r.resolve(stmt)
+		}
+	case *syntax.DoStatement:
+		r.block(t.Block)
+	case *syntax.WhileStatement:
+		r.resolve(t.Exp)
+		r.block(t.Block)
+	case *syntax.IfStatement:
+		r.resolve(t.Exp)
+		r.block(t.TrueBranch)
+		r.resolve(t.FalseBranch)
+	case *syntax.ElseifStatement:
+		r.resolve(t.Exp)
+		r.block(t.TrueBranch)
+		r.resolve(t.FalseBranch)
+	case *syntax.ElseStatement:
+		r.block(t.Block)
+	case *syntax.LocalNameListStatement:
+		// The expressions are resolved before the names come into scope,
+		// so 'local x = x' reads the outer x.
+		r.resolve(t.ExpList)
+		for _, name := range t.NameList.(*syntax.NameList).Names {
+			v := &Variable{Name: name, Scope: r.scope}
+			r.scope.Vars = append(r.scope.Vars, v)
+			r.info.Decls[name] = v
+		}
+	case *syntax.AssignmentStatement:
+		r.resolve(t.ExpList)
+		for _, v := range t.VarList.(*syntax.VarList).VarList {
+			r.name(v.(*syntax.Terminator), true)
+		}
+	case *syntax.ExpressionList:
+		for _, exp := range t.ExpList {
+			r.resolve(exp)
+		}
+	case *syntax.BinaryExpression:
+		r.resolve(t.Left)
+		r.resolve(t.Right)
+	case *syntax.UnaryExpression:
+		r.resolve(t.Exp)
+	case *syntax.ParenExpression:
+		r.resolve(t.Exp)
+	case *syntax.Terminator:
+		if t.Token.Category == scanner.TokenID {
+			r.name(t, false)
+		}
+	default:
+		panic("slua/resolver internal error: unknown syntax tree")
+	}
+}
+
+// name resolves a name that is read, or assigned to if write is set. Both
+// reading and setting a global read the '_ENV' it goes through.
+func (r *resolver) name(t *syntax.Terminator, write bool) {
+	res := &Resolution{Kind: Global}
+	if v := r.scope.Lookup(t.Token.Value.(string)); v != nil {
+		res.Kind = Local
+		res.Var = v
+		if write {
+			v.Writes = append(v.Writes, t)
+		} else {
+			v.Refs = append(v.Refs, t)
+		}
+	} else {
+		res.Env = r.scope.Lookup(envName)
+		if res.Env != nil {
+			res.Env.Refs = append(res.Env.Refs, t)
+		}
+	}
+	r.info.Names[t] = res
+}
@@ -0,0 +1,203 @@
+package resolver_test
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/ksco/slua/parser"
+	"github.com/ksco/slua/resolver"
+	"github.com/ksco/slua/scanner"
+	"github.com/ksco/slua/syntax"
+)
+
+func parse(src string) syntax.SyntaxTree {
+	return parser.New(scanner.New(strings.NewReader(src))).Parse()
+}
+
+func pos(t *scanner.Token) string {
+	return fmt.Sprintf("%v:%v", t.Line, t.Column)
+}
+
+// names describes what every name in info resolves to, in source order.
+func names(info *resolver.Info) []string {
+	var terms []*syntax.Terminator
+	for t := range info.Names {
+		terms = append(terms, t)
+	}
+	sort.Slice(terms, func(i, j int) bool {
+		return terms[i].Token.Offset < terms[j].Token.Offset
+	})
+	var list []string
+	for _, t := range terms {
+		res := info.Names[t]
+		s := pos(t.Token) + " " + t.Token.Value.(string) + " "
+		switch {
+		case res.Kind == resolver.Local:
+			s += "local " + pos(res.Var.Name)
+		case res.Env != nil:
+			s += "global in _ENV " + pos(res.Env.Name)
+		default:
+			s += "global"
+		}
+		list = append(list, s)
+	}
+	return list
+}
+
+var nameTests = []struct {
+	name, src string
+	want      []string
+}{
+	{"global", "x = y", []string{"1:1 x global", "1:5 y global"}},
+	{"local", "local x = 1\ny = x", []string{
+		"2:1 y global", "2:5 x local 1:7",
+	}},
+	{"local in its own value", "local x = x", []string{"1:11 x global"}},
+	{"outer local in an inner one's value",
+		"local x = 1\ndo local x = x y = x end", []string{
+			"2:14 x local 1:7", "2:16 y global", "2:20 x local 2:10",
+		}},
+	{"shadowed in the same scope", "local x = 1\nlocal x = x\ny = x",
+		[]string{"2:11 x local 1:7", "3:1 y global", "3:5 x local 2:7"}},
+	{"shadowed in an inner scope",
+		"local x = 1\ndo local x = 2 y = x end\ny = x", []string{
+			"2:16 y global", "2:20 x local 2:10",
+			"3:1 y global", "3:5 x local 1:7",
+		}},
+	{"sibling blocks", "do local a = 1 end do y = a end", []string{
+		"1:23 y global", "1:27 a global",
+	}},
+	{"while", "while x do local x = 1 y = x end", []string{
+		"1:7 x global", "1:24 y global", "1:28 x local 1:18",
+	}},
+	{"if branches",
+		"if c then local a = 1 elseif a then local b = a else y = b end",
+		[]string{
+			"1:4 c global", "1:30 a global", "1:47 a global",
+			"1:54 y global", "1:58 b global",
+		}},
+	{"outer local in if branches",
+		"local a\nif a then local a = 1 y = a else y = a end", []string{
+			"2:4 a local 1:7", "2:23 y global", "2:27 a local 2:17",
+			"2:34 y global", "2:38 a local 1:7",
+		}},
+	{"local _ENV", "x = 1\nlocal _ENV = y\nx = 2\n" +
+		"do local _ENV = z x = 3 end\nx = _ENV", []string{
+		"1:1 x global",
+		"2:14 y global",
+		"3:1 x global in _ENV 2:7",
+		"4:17 z global in _ENV 2:7",
+		"4:19 x global in _ENV 4:10",
+		"5:1 x global in _ENV 2:7",
+		"5:5 _ENV local 2:7",
+	}},
+	{"_ENV in a sibling block", "do local _ENV = e end x = 1", []string{
+		"1:17 e global", "1:23 x global",
+	}},
+}
+
+func TestNames(t *testing.T) {
+	for _, test := range nameTests {
+		got := names(resolver.Resolve(parse(test.src)))
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%v: got %q, want %q", test.name, got, test.want)
+		}
+	}
+}
+
+func positions(terms []*syntax.Terminator) []string {
+	list := []string{}
+	for _, t := range terms {
+		list = append(list, pos(t.Token))
+	}
+	return list
+}
+
+func TestRefsAndWrites(t *testing.T) {
+	tree := parse("local a, b = 1\na = 2\nb = a\na, b = b, a\n" +
+		"local _ENV = a\ng = 1\nlocal c")
+	info := resolver.Resolve(tree)
+	want := []struct {
+		name         string
+		refs, writes []string
+	}{
+		{"a", []string{"3:5", "4:11", "5:14"}, []string{"2:1", "4:1"}},
+		{"b", []string{"4:8"}, []string{"3:1", "4:4"}},
+		// Setting a global reads the _ENV it goes through.
+		{"_ENV", []string{"6:1"}, []string{}},
+		{"c", []string{}, []string{}},
+	}
+	vars := info.Scope.Vars
+	if len(vars) != len(want) {
+		t.Fatalf("%v locals, want %v", len(vars), len(want))
+	}
+	for i, w := range want {
+		v := vars[i]
+		if v.Name.Value != w.name {
+			t.Errorf("local %v is %v, want %v", i, v.Name.Value, w.name)
+		}
+		if got := positions(v.Refs); !reflect.DeepEqual(got, w.refs) {
+			t.Errorf("%v: refs %v, want %v", w.name, got, w.refs)
+		}
+		if got := positions(v.Writes); !reflect.DeepEqual(got, w.writes) {
+			t.Errorf("%v: writes %v, want %v", w.name, got, w.writes)
+		}
+		if info.Decls[v.Name] != v {
+			t.Errorf("%v: not in Decls", w.name)
+		}
+	}
+}
+
+// scopes describes s as its locals followed by its children in brackets.
+func scopes(s *resolver.Scope) string {
+	var b strings.Builder
+	for i, v := range s.Vars {
+		if i > 0 {
+			b.WriteString(" ")
+		}
+		b.WriteString(v.Name.Value.(string))
+	}
+	for _, c := range s.Children {
+		b.WriteString("[" + scopes(c) + "]")
+	}
+	return b.String()
+}
+
+func TestScopes(t *testing.T) {
+	tree := parse("local a\ndo local b do local c end end\n" +
+		"while a do local d end\n" +
+		"if a then local e elseif a then local f else local g end\n" +
+		"local h")
+	info := resolver.Resolve(tree)
+	want := "a h[b[c]][d][e][f][g]"
+	if got := scopes(info.Scope); got != want {
+		t.Errorf("scopes %v, want %v", got, want)
+	}
+
+	root := info.Scope
+	if root.Parent != nil || root.Block != tree.(*syntax.Chunk).Block {
+		t.Errorf("root scope: %+v", root)
+	}
+	do := root.Children[0]
+	if do.Parent != root || do.Children[0].Parent != do {
+		t.Error("parents do not match children")
+	}
+	stmts := tree.(*syntax.Chunk).Block.(*syntax.Block).Stmts
+	if do.Block != stmts[1].(*syntax.DoStatement).Block {
+		t.Error("scope of 'do' is not its block")
+	}
+
+	inner := do.Children[0]
+	if v := inner.Lookup("a"); v != root.Vars[0] {
+		t.Errorf("Lookup(\"a\") in the inner block: %v", v)
+	}
+	if v := inner.Lookup("b"); v != do.Vars[0] {
+		t.Errorf("Lookup(\"b\") in the inner block: %v", v)
+	}
+	if v := root.Lookup("b"); v != nil {
+		t.Errorf("Lookup(\"b\") at the top: %v", v)
+	}
+}
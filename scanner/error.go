@@ -3,12 +3,36 @@ package scanner
 import "fmt"
 
 type Error struct {
-	module string
-	line   int
-	column int
-	str    string
+	module     string
+	line       int
+	column     int
+	str        string
+	incomplete bool
+	err        error
 }
 
 func (e *Error) Error() string {
 	return fmt.Sprintf("%v:%v:%v %v", e.module, e.line, e.column, e.str)
 }
+
+// Position returns the line and column the error was found at.
+func (e *Error) Position() (line, column int) {
+	return e.line, e.column
+}
+
+// Message returns the error without its position.
+func (e *Error) Message() string {
+	return e.str
+}
+
+// Incomplete reports whether the error was caused by the input ending in the
+// middle of a token, so that more input could make it valid.
+func (e *Error) Incomplete() bool {
+	return e.incomplete
+}
+
+// Unwrap returns the error the reader failed with, or nil if the input
+// itself was wrong.
+func (e *Error) Unwrap() error {
+	return e.err
+}
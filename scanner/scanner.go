@@ -1,39 +1,94 @@
 package scanner
 
 import (
+	"bufio"
+	"bytes"
 	"io"
 	"strconv"
 	"unicode"
+	"unicode/utf8"
 
 	"github.com/ksco/slua/ascii"
 )
 
+type reader interface {
+	io.RuneScanner
+	io.ByteReader
+}
+
+// A Scanner reads one chunk and must not be used from more than one
+// goroutine at a time. Scanners share no state, so separate ones can run in
+// parallel.
 type Scanner struct {
 	module  string
-	reader  io.RuneReader
+	reader  reader
 	current rune
-	line    int
-	column  int
-	buffer  []rune
+	// raw is set when current is a byte that is not valid UTF-8. current is
+	// then utf8.RuneError and the byte itself is in rawByte.
+	raw     bool
+	rawByte byte
+	started bool
+	// atEOF is set once current is past the end of input. A NUL rune in
+	// current is an ordinary character.
+	atEOF bool
+
+	// Position of current. column counts runes from 1, offset counts bytes
+	// from 0 and read is the number of bytes read so far.
+	line   int
+	column int
+	offset int
+	read   int
+
+	// Position of the first character of the token being scanned.
+	tokenLine   int
+	tokenColumn int
+	tokenOffset int
+
+	buffer []byte
+
+	onComment func(*Token)
 }
 
-const eof rune = 0
+const (
+	eof rune = 0
+	bom rune = 0xFEFF
+)
 
-func New(reader io.RuneReader) *Scanner {
+// New returns a scanner reading from r. Readers that cannot read and unread
+// runes and bytes are buffered.
+func New(r io.Reader) *Scanner {
 	s := new(Scanner)
 	s.module = "scanner"
-	s.reader = reader
+	if rs, ok := r.(reader); ok {
+		s.reader = rs
+	} else {
+		s.reader = bufio.NewReader(r)
+	}
 	s.line = 1
 	s.current = eof
 	return s
 }
 
+// NewBytes returns a scanner reading from src.
+func NewBytes(src []byte) *Scanner {
+	return New(bytes.NewReader(src))
+}
+
+// OnComment makes the scanner call f with every comment it skips. The
+// token's value is the text after the "--".
+func (s *Scanner) OnComment(f func(*Token)) {
+	s.onComment = f
+}
+
 func (s *Scanner) Scan() *Token {
-	if s.current == eof {
-		s.current = s.next()
+	if !s.started {
+		s.start()
 	}
 
-	for s.current != eof {
+	for !s.atEOF {
+		s.tokenLine = s.line
+		s.tokenColumn = s.column
+		s.tokenOffset = s.offset
 		switch s.current {
 		case ' ', '\t', '\v', '\f': // Skip whitespace
 			s.current = s.next()
@@ -43,6 +98,9 @@ func (s *Scanner) Scan() *Token {
 			n := s.next()
 			if n == '-' {
 				s.comment()
+				if s.onComment != nil {
+					s.onComment(s.stringToken(string(s.buffer), TokenComment))
+				}
 			} else {
 				s.current = n
 				return s.normalToken(TokenSub)
@@ -57,11 +115,16 @@ func (s *Scanner) Scan() *Token {
 		case '.':
 			n := s.next()
 			if n == '.' {
-				s.current = s.next()
+				n = s.next()
+				if n == '.' {
+					s.current = s.next()
+					return s.normalToken(TokenVarArg)
+				}
+				s.current = n
 				return s.normalToken(TokenConcat)
 			} else {
 				s.buffer = s.buffer[:0]
-				s.buffer = append(s.buffer, s.current)
+				s.save()
 				s.current = n
 				return s.number(true)
 			}
@@ -89,7 +152,10 @@ func (s *Scanner) Scan() *Token {
 			return s.id()
 		}
 	}
-	return NewToken()
+	s.tokenLine = s.line
+	s.tokenColumn = s.column
+	s.tokenOffset = s.offset
+	return s.normalToken(TokenEOF)
 }
 
 // Helper functions
@@ -99,10 +165,25 @@ func isLetter(ch rune) bool {
 		ch >= 0x80 && unicode.IsLetter(ch)
 }
 
+// IsName reports whether s scans as a single <id> token: a letter or '_'
+// followed by letters, digits and '_', and not a keyword.
+func IsName(s string) bool {
+	if s == "" || !utf8.ValidString(s) || isKeyword(s) {
+		return false
+	}
+	for i, ch := range s {
+		if !isLetter(ch) && (i == 0 || !unicode.IsDigit(ch)) {
+			return false
+		}
+	}
+	return true
+}
+
 func (s *Scanner) normalToken(category string) *Token {
 	return &Token{
-		Line:     s.line,
-		Column:   s.column,
+		Line:     s.tokenLine,
+		Column:   s.tokenColumn,
+		Offset:   s.tokenOffset,
 		Category: category,
 	}
 }
@@ -113,55 +194,115 @@ func (s *Scanner) stringToken(value, category string) *Token {
 	return t
 }
 
-func (s *Scanner) numberToken(value float64) *Token {
+func (s *Scanner) numberToken(value interface{}) *Token {
 	t := s.normalToken(TokenNumber)
 	t.Value = value
 	return t
 }
 
+// start reads the first character, skipping a UTF-8 byte order mark and a
+// first line starting with '#', like the '#!' line of a script.
+func (s *Scanner) start() {
+	s.started = true
+	s.current = s.next()
+	if s.current == bom {
+		s.column = 0
+		s.current = s.next()
+	}
+	if s.current == '#' {
+		s.comment()
+	}
+}
+
+// next reads the next character and moves the position to it. At the end
+// of input the position stays one past the last character.
 func (s *Scanner) next() rune {
-	ch, _, err := s.reader.ReadRune()
-	if err == nil {
-		s.column++
+	ch, size, err := s.reader.ReadRune()
+	if err != nil {
+		if err != io.EOF {
+			panic(&Error{
+				module: s.module,
+				line:   s.line,
+				column: s.column,
+				str:    "read error: " + err.Error(),
+				err:    err,
+			})
+		}
+		if !s.atEOF {
+			s.atEOF = true
+			s.column++
+			s.offset = s.read
+		}
+		return eof
+	}
+	s.column++
+	s.offset = s.read
+	s.read += size
+	s.raw = ch == utf8.RuneError && size == 1
+	if s.raw {
+		s.reader.UnreadRune()
+		s.rawByte, _ = s.reader.ReadByte()
 	}
 	return ch
 }
 
+// save appends current to the buffer, byte for byte as it was read.
+func (s *Scanner) save() {
+	if s.raw {
+		s.buffer = append(s.buffer, s.rawByte)
+	} else {
+		s.buffer = utf8.AppendRune(s.buffer, s.current)
+	}
+}
+
+// newLine skips a line break. "\r\n" and "\n\r" count as one break.
 func (s *Scanner) newLine() {
+	old := s.current
+	s.line++
+	s.column = 0
 	ch := s.next()
-	if (ch == '\r' || ch == '\n') && ch != s.current {
+	if (ch == '\r' || ch == '\n') && ch != old {
+		s.column = 0
 		s.current = s.next()
 	} else {
 		s.current = ch
 	}
-	s.line++
-	s.column = 0
 }
 
+// comment skips the rest of the line and leaves it in the buffer.
 func (s *Scanner) comment() {
+	s.buffer = s.buffer[:0]
 	s.current = s.next()
-	for s.current != '\r' && s.current != '\n' && s.current != eof {
+	for s.current != '\r' && s.current != '\n' && !s.atEOF {
+		s.save()
 		s.current = s.next()
 	}
 }
 
+// number reads a numeral. One without a '.' is an integer unless it does
+// not fit in an int64, in which case it is a float like any other.
 func (s *Scanner) number(point bool) *Token {
 	if !point {
 		s.buffer = s.buffer[:0]
 		for unicode.IsDigit(s.current) {
-			s.buffer = append(s.buffer, s.current)
+			s.save()
 			s.current = s.next()
 		}
 		if s.current == '.' {
-			s.buffer = append(s.buffer, s.current)
+			s.save()
 			s.current = s.next()
 		}
 	}
 	for unicode.IsDigit(s.current) {
-		s.buffer = append(s.buffer, s.current)
+		s.save()
 		s.current = s.next()
 	}
 	str := string(s.buffer)
+	if bytes.IndexByte(s.buffer, '.') < 0 {
+		if n, err := strconv.ParseInt(str, 10, 64); err == nil {
+			return s.numberToken(n)
+		}
+	}
 	number, err := strconv.ParseFloat(str, 64)
 	if err != nil {
 		panic(&Error{
@@ -208,6 +349,12 @@ func (s *Scanner) stringChar() {
 			s.buffer = append(s.buffer, '"')
 		} else if s.current == '\'' {
 			s.buffer = append(s.buffer, '\'')
+		} else if s.current == 'x' {
+			s.hexEscape()
+			return
+		} else if '0' <= s.current && s.current <= '9' {
+			s.decimalEscape()
+			return
 		} else {
 			panic(&Error{
 				module: s.module,
@@ -217,22 +364,74 @@ func (s *Scanner) stringChar() {
 			})
 		}
 	} else {
-		s.buffer = append(s.buffer, s.current)
+		s.save()
+	}
+	s.current = s.next()
+}
+
+// hexEscape reads the two digits of a '\xXX' escape.
+func (s *Scanner) hexEscape() {
+	var b byte
+	for i := 0; i < 2; i++ {
+		s.current = s.next()
+		d, ok := hexDigit(s.current)
+		if !ok {
+			panic(&Error{
+				module: s.module,
+				line:   s.line,
+				column: s.column,
+				str:    "hexadecimal digit expected",
+			})
+		}
+		b = b<<4 | d
 	}
+	s.buffer = append(s.buffer, b)
 	s.current = s.next()
 }
 
+// decimalEscape reads the up to three digits of a '\ddd' escape.
+func (s *Scanner) decimalEscape() {
+	n := 0
+	for i := 0; i < 3 && '0' <= s.current && s.current <= '9'; i++ {
+		n = n*10 + int(s.current-'0')
+		s.current = s.next()
+	}
+	if n > 255 {
+		panic(&Error{
+			module: s.module,
+			line:   s.line,
+			column: s.column,
+			str:    "decimal escape too large",
+		})
+	}
+	s.buffer = append(s.buffer, byte(n))
+}
+
+func hexDigit(ch rune) (byte, bool) {
+	switch {
+	case '0' <= ch && ch <= '9':
+		return byte(ch - '0'), true
+	case 'a' <= ch && ch <= 'f':
+		return byte(ch-'a') + 10, true
+	case 'A' <= ch && ch <= 'F':
+		return byte(ch-'A') + 10, true
+	default:
+		return 0, false
+	}
+}
+
 func (s *Scanner) singlelineString() *Token {
 	quote := s.current
 	s.current = s.next()
 	s.buffer = s.buffer[:0]
 	for s.current != quote {
-		if s.current == eof {
+		if s.atEOF {
 			panic(&Error{
-				module: s.module,
-				line:   s.line,
-				column: s.column,
-				str:    "incomplete string at <eof>",
+				module:     s.module,
+				line:       s.line,
+				column:     s.column,
+				str:        "incomplete string at <eof>",
+				incomplete: true,
 			})
 		}
 		if s.current == '\r' || s.current == '\n' {
@@ -260,10 +459,10 @@ func (s *Scanner) id() *Token {
 	}
 
 	s.buffer = s.buffer[:0]
-	s.buffer = append(s.buffer, s.current)
+	s.save()
 	s.current = s.next()
 	for isLetter(s.current) || unicode.IsDigit(s.current) {
-		s.buffer = append(s.buffer, s.current)
+		s.save()
 		s.current = s.next()
 	}
 
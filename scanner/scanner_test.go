@@ -0,0 +1,333 @@
+package scanner_test
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"math"
+	"reflect"
+	"strings"
+	"testing"
+	"testing/iotest"
+
+	"github.com/ksco/slua/scanner"
+)
+
+// pos is where a token starts: its category, line, column and offset.
+type pos struct {
+	category     string
+	line, column int
+	offset       int
+}
+
+func scanAll(s *scanner.Scanner) []pos {
+	var list []pos
+	for {
+		t := s.Scan()
+		list = append(list, pos{t.Category, t.Line, t.Column, t.Offset})
+		if t.Category == scanner.TokenEOF {
+			return list
+		}
+	}
+}
+
+// plainReader hides every method but Read, so the scanner has to buffer
+// it.
+type plainReader struct {
+	r io.Reader
+}
+
+func (r plainReader) Read(p []byte) (int, error) {
+	return r.r.Read(p)
+}
+
+var positionTests = []struct {
+	name, src string
+	want      []pos
+}{
+	{"empty", "", []pos{{"<eof>", 1, 1, 0}}},
+	{"one token", "ab", []pos{
+		{"<id>", 1, 1, 0}, {"<eof>", 1, 3, 2},
+	}},
+	{"LF", "a\nb\n", []pos{
+		{"<id>", 1, 1, 0}, {"<id>", 2, 1, 2}, {"<eof>", 3, 1, 4},
+	}},
+	{"CRLF", "a\r\nb\r\n", []pos{
+		{"<id>", 1, 1, 0}, {"<id>", 2, 1, 3}, {"<eof>", 3, 1, 6},
+	}},
+	{"LF-CR", "a\n\rb\n\r", []pos{
+		{"<id>", 1, 1, 0}, {"<id>", 2, 1, 3}, {"<eof>", 3, 1, 6},
+	}},
+	{"CR", "a\rb", []pos{
+		{"<id>", 1, 1, 0}, {"<id>", 2, 1, 2}, {"<eof>", 2, 2, 3},
+	}},
+	{"CR-CR", "a\r\rb", []pos{
+		{"<id>", 1, 1, 0}, {"<id>", 3, 1, 3}, {"<eof>", 3, 2, 4},
+	}},
+	{"LF-LF", "a\n\nb", []pos{
+		{"<id>", 1, 1, 0}, {"<id>", 3, 1, 3}, {"<eof>", 3, 2, 4},
+	}},
+	{"mixed", "a\r\n\nb\n\r\rc  d", []pos{
+		{"<id>", 1, 1, 0}, {"<id>", 3, 1, 4}, {"<id>", 5, 1, 8},
+		{"<id>", 5, 4, 11}, {"<eof>", 5, 5, 12},
+	}},
+	{"CRLF-LF-CR", "a\r\n\n\rb", []pos{
+		{"<id>", 1, 1, 0}, {"<id>", 3, 1, 5}, {"<eof>", 3, 2, 6},
+	}},
+	{"columns count runes", "é = \"ü\" x", []pos{
+		{"<id>", 1, 1, 0}, {"=", 1, 3, 3}, {"<string>", 1, 5, 5},
+		{"<id>", 1, 9, 10}, {"<eof>", 1, 10, 11},
+	}},
+	{"tab and comment", "\tx -- c\r\ny", []pos{
+		{"<id>", 1, 2, 1}, {"<id>", 2, 1, 9}, {"<eof>", 2, 2, 10},
+	}},
+	{"BOM", "\ufeffa b", []pos{
+		{"<id>", 1, 1, 3}, {"<id>", 1, 3, 5}, {"<eof>", 1, 4, 6},
+	}},
+	{"shebang", "#!/usr/bin/lua\r\nx", []pos{
+		{"<id>", 2, 1, 16}, {"<eof>", 2, 2, 17},
+	}},
+	{"BOM and shebang", "\ufeff#!lua\ny", []pos{
+		{"<id>", 2, 1, 9}, {"<eof>", 2, 2, 10},
+	}},
+	{"eof after operator", "x ..", []pos{
+		{"<id>", 1, 1, 0}, {"..", 1, 3, 2}, {"<eof>", 1, 5, 4},
+	}},
+}
+
+func TestPositions(t *testing.T) {
+	readers := []struct {
+		name string
+		new  func(src string) *scanner.Scanner
+	}{
+		{"New", func(src string) *scanner.Scanner {
+			return scanner.New(strings.NewReader(src))
+		}},
+		{"NewBytes", func(src string) *scanner.Scanner {
+			return scanner.NewBytes([]byte(src))
+		}},
+		{"plain reader", func(src string) *scanner.Scanner {
+			return scanner.New(plainReader{strings.NewReader(src)})
+		}},
+	}
+	for _, r := range readers {
+		for _, test := range positionTests {
+			got := scanAll(r.new(test.src))
+			if len(got) != len(test.want) {
+				t.Errorf("%v %v: got %v, want %v", r.name, test.name,
+					got, test.want)
+				continue
+			}
+			for i := range got {
+				if got[i] != test.want[i] {
+					t.Errorf("%v %v: token %v is %v, want %v", r.name,
+						test.name, i, got[i], test.want[i])
+				}
+			}
+		}
+	}
+}
+
+// scanValues scans r to the end and returns the values of its tokens, or
+// the error the scanner stopped with.
+func scanValues(r io.Reader) (values []interface{}, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			e, ok := r.(*scanner.Error)
+			if !ok {
+				panic(r)
+			}
+			values, err = nil, e
+		}
+	}()
+	s := scanner.New(r)
+	for {
+		t := s.Scan()
+		if t.Category == scanner.TokenEOF {
+			return values, nil
+		}
+		values = append(values, t.Value)
+	}
+}
+
+var valueTests = []struct {
+	name, src string
+	want      []interface{}
+	err       string
+}{
+	{"NUL in a string", "'a\x00b'", []interface{}{"a\x00b"}, ""},
+	{"NUL alone in a string", "\"\x00\"", []interface{}{"\x00"}, ""},
+	{"NUL escape", "'\\0'", []interface{}{"\x00"}, ""},
+	{"NUL in a comment", "-- a\x00b\nx", []interface{}{"x"}, ""},
+	{"NUL outside a string", "a \x00", nil, "1:3 unexpect character"},
+	{"NUL after a name", "a\x00", nil, "1:2 unexpect character"},
+
+	{"hex escape", "'\\xFF\\x41\\x0a'", []interface{}{"\xffA\n"}, ""},
+	{"short hex escape", "'\\x4'", nil, "hexadecimal digit expected"},
+	{"hex escape at the end", "'\\x", nil, "hexadecimal digit expected"},
+	{"decimal escape", "'\\255'", []interface{}{"\xff"}, ""},
+	{"decimal escape too large", "'\\256'", nil,
+		"decimal escape too large"},
+	{"decimal escapes in a row", "'\\65\\066'", []interface{}{"AB"}, ""},
+	{"decimal escape of three digits at most", "'\\1234'",
+		[]interface{}{"{4"}, ""},
+	{"invalid UTF-8 in a string", "'a\xffb\xc3'",
+		[]interface{}{"a\xffb\xc3"}, ""},
+	{"invalid UTF-8 in a comment", "--\xfe\nx", []interface{}{"x"}, ""},
+	{"invalid UTF-8 outside a string", "\xff", nil,
+		"1:1 unexpect character"},
+}
+
+func TestValues(t *testing.T) {
+	for _, test := range valueTests {
+		got, err := scanValues(strings.NewReader(test.src))
+		switch {
+		case test.err != "":
+			if err == nil || !strings.Contains(err.Error(), test.err) {
+				t.Errorf("%v: got %q, error %v, want error %q", test.name,
+					got, err, test.err)
+			}
+		case err != nil:
+			t.Errorf("%v: %v", test.name, err)
+		case !reflect.DeepEqual(got, test.want):
+			t.Errorf("%v: got %q, want %q", test.name, got, test.want)
+		}
+	}
+}
+
+var errBroken = errors.New("broken reader")
+
+func TestReadError(t *testing.T) {
+	tests := []struct {
+		name   string
+		before string
+		pos    string
+	}{
+		{"at the start", "", "1:0"},
+		{"between tokens", "a = ", "1:4"},
+		{"inside a name", "abc", "1:3"},
+		{"inside a string", "'ab", "1:3"},
+		{"inside a comment", "x -- c", "1:6"},
+	}
+	for _, test := range tests {
+		r := io.MultiReader(strings.NewReader(test.before),
+			iotest.ErrReader(errBroken))
+		_, err := scanValues(r)
+		var e *scanner.Error
+		if !errors.As(err, &e) {
+			t.Errorf("%v: got %v, want a *scanner.Error", test.name, err)
+			continue
+		}
+		if errors.Unwrap(err) != errBroken {
+			t.Errorf("%v: %v unwraps to %v", test.name, err,
+				errors.Unwrap(err))
+		}
+		want := "scanner:" + test.pos + " read error: broken reader"
+		if err.Error() != want {
+			t.Errorf("%v: got %q, want %q", test.name, err, want)
+		}
+	}
+	if _, err := scanValues(strings.NewReader("a 'b'")); err != nil {
+		t.Errorf("clean input: %v", err)
+	}
+	if e := new(scanner.Error); e.Unwrap() != nil {
+		t.Errorf("Unwrap of a syntax error: %v", e.Unwrap())
+	}
+}
+
+func TestEOFRepeats(t *testing.T) {
+	s := scanner.New(strings.NewReader("a\n"))
+	s.Scan()
+	for i := 0; i < 3; i++ {
+		e := s.Scan()
+		if e.Category != scanner.TokenEOF || e.Line != 2 || e.Column != 1 ||
+			e.Offset != 2 {
+			t.Errorf("scan %v after end: %v %v:%v at %v", i, e.Category,
+				e.Line, e.Column, e.Offset)
+		}
+	}
+}
+
+func TestNumbers(t *testing.T) {
+	tests := []struct {
+		src  string
+		want interface{}
+	}{
+		{"0", int64(0)},
+		{"42", int64(42)},
+		{"9223372036854775807", int64(9223372036854775807)},
+		{"9223372036854775808", float64(9223372036854775808)},
+		{"1.0", float64(1)},
+		{"1.", float64(1)},
+		{".5", float64(0.5)},
+	}
+	for _, test := range tests {
+		tok := scanner.New(strings.NewReader(test.src)).Scan()
+		if tok.Category != scanner.TokenNumber || tok.Value != test.want {
+			t.Errorf("%v: got %v %#v, want %#v", test.src, tok.Category,
+				tok.Value, test.want)
+		}
+	}
+}
+
+func TestNumberJSON(t *testing.T) {
+	tests := []struct {
+		value interface{}
+		json  string
+	}{
+		{int64(0), "0"},
+		{int64(-7), "-7"},
+		{int64(math.MaxInt64), "9223372036854775807"},
+		{float64(0), "0.0"},
+		{float64(1), "1.0"},
+		{0.5, "0.5"},
+		{1e300, "1e+300"},
+		{math.Copysign(0, -1), "-0.0"},
+	}
+	for _, test := range tests {
+		tok := &scanner.Token{Value: test.value, Category: scanner.TokenNumber}
+		data, err := json.Marshal(tok)
+		if err != nil {
+			t.Fatalf("%v: %v", test.value, err)
+		}
+		if want := `"value":` + test.json + `,`; !strings.Contains(
+			string(data), want) {
+			t.Errorf("%#v encodes as %s, want %v", test.value, data, want)
+		}
+		back := new(scanner.Token)
+		if err := json.Unmarshal(data, back); err != nil {
+			t.Fatalf("%s: %v", data, err)
+		}
+		if back.Value != test.value {
+			t.Errorf("%s decodes as %#v, want %#v", data, back.Value,
+				test.value)
+		}
+		if f, ok := test.value.(float64); ok &&
+			math.Signbit(back.Value.(float64)) != math.Signbit(f) {
+			t.Errorf("%s loses the sign of zero", data)
+		}
+	}
+}
+
+func TestIsName(t *testing.T) {
+	tests := []struct {
+		s    string
+		want bool
+	}{
+		{"a", true},
+		{"_", true},
+		{"a1_b", true},
+		{"é", true},
+		{"", false},
+		{"1a", false},
+		{"a b", false},
+		{"a-b", false},
+		{"end", false},
+		{"\xff", false},
+	}
+	for _, test := range tests {
+		if got := scanner.IsName(test.s); got != test.want {
+			t.Errorf("IsName(%q) = %v, want %v", test.s, got, test.want)
+		}
+	}
+}
@@ -1,6 +1,13 @@
 package scanner
 
-import "fmt"
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
 
 const (
 	TokenAnd          string = "and"
@@ -37,6 +44,8 @@ const (
 	TokenGreater             = ">"
 	TokenGreaterEqual        = ">="
 	TokenConcat              = ".."
+	TokenVarArg              = "..."
+	TokenComment             = "<comment>"
 	TokenEOF                 = "<eof>"
 )
 
@@ -44,9 +53,22 @@ type Token struct {
 	Value    interface{}
 	Line     int
 	Column   int
+	Offset   int
 	Category string
 }
 
+// tokenJSON is the JSON form of a Token. A string value that is not valid
+// UTF-8 cannot be a JSON string, so it goes in Bytes instead. A float value
+// is always written with a '.' or an exponent to tell it from an integer.
+type tokenJSON struct {
+	Value    interface{} `json:"value,omitempty"`
+	Bytes    []byte      `json:"bytes,omitempty"`
+	Line     int         `json:"line"`
+	Column   int         `json:"column"`
+	Offset   int         `json:"offset"`
+	Category string      `json:"category"`
+}
+
 func NewToken() *Token {
 	token := new(Token)
 	token.Category = TokenEOF
@@ -69,10 +91,84 @@ func (t *Token) Clone() *Token {
 		Value:    t.Value,
 		Line:     t.Line,
 		Column:   t.Column,
+		Offset:   t.Offset,
 		Category: t.Category,
 	}
 }
 
+func (t *Token) MarshalJSON() ([]byte, error) {
+	j := tokenJSON{
+		Value:    t.Value,
+		Line:     t.Line,
+		Column:   t.Column,
+		Offset:   t.Offset,
+		Category: t.Category,
+	}
+	switch v := t.Value.(type) {
+	case string:
+		if !utf8.ValidString(v) {
+			j.Value = nil
+			j.Bytes = []byte(v)
+		}
+	case int64:
+		j.Value = json.Number(strconv.FormatInt(v, 10))
+	case float64:
+		j.Value = json.Number(formatFloat(v))
+	}
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(j); err != nil {
+		return nil, err
+	}
+	return bytes.TrimSpace(buf.Bytes()), nil
+}
+
+func (t *Token) UnmarshalJSON(data []byte) error {
+	var j tokenJSON
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	if err := dec.Decode(&j); err != nil {
+		return err
+	}
+	if n, ok := j.Value.(json.Number); ok {
+		j.Value = parseNumber(string(n))
+	}
+	*t = Token{
+		Value:    j.Value,
+		Line:     j.Line,
+		Column:   j.Column,
+		Offset:   j.Offset,
+		Category: j.Category,
+	}
+	if j.Bytes != nil {
+		t.Value = string(j.Bytes)
+	}
+	return nil
+}
+
+// formatFloat writes a float so that it reads back as a float: with a '.'
+// or an exponent even when it has no fraction.
+func formatFloat(f float64) string {
+	s := strconv.FormatFloat(f, 'g', -1, 64)
+	if !strings.ContainsAny(s, ".eIN") {
+		s += ".0"
+	}
+	return s
+}
+
+// parseNumber reads a JSON number as an int64 if it is written as an
+// integer and fits, and as a float64 otherwise.
+func parseNumber(s string) interface{} {
+	if !strings.ContainsAny(s, ".eE") {
+		if n, err := strconv.ParseInt(s, 10, 64); err == nil {
+			return n
+		}
+	}
+	f, _ := strconv.ParseFloat(s, 64)
+	return f
+}
+
 func isKeyword(id string) bool {
 	switch id {
 	case TokenAnd, TokenDo, TokenElse, TokenElseif, TokenEnd,
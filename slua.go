@@ -2,20 +2,182 @@ package main
 
 import (
 	"fmt"
+	"io"
+	"os"
 	"strings"
 
-	"github.com/ksco/SLua/parser"
+	"github.com/ksco/slua/parser"
 	"github.com/ksco/slua/scanner"
 )
 
+const (
+	progName = "slua"
+	version  = "SLua 0.1"
+)
+
+const usage = `usage: %[1]s [options] [script [args]]
+       %[1]s tokens file
+       %[1]s ast [-json] [-O] file
+       %[1]s print file.json
+       %[1]s lsp
+       %[1]s lint [-config file] file...
+Available options are:
+  -e stat  execute string 'stat'
+  -i       enter interactive mode after executing 'script'
+  -l name  require library 'name'
+  -v       show version information
+  -E       ignore environment variables
+  --       stop handling options
+  -        stop handling options and execute stdin
+`
+
+type options struct {
+	interactive bool
+	version     bool
+	noEnv       bool
+	// exec holds the -e and -l options in command line order.
+	exec   [][2]string
+	script int
+}
+
+// collectArgs parses the options in front of the script name. script is the
+// index of the script in args, or len(args) when there is none.
+func collectArgs(args []string) (*options, error) {
+	o := &options{script: len(args)}
+	for i := 1; i < len(args); i++ {
+		arg := args[i]
+		if !strings.HasPrefix(arg, "-") {
+			o.script = i
+			return o, nil
+		}
+		switch arg {
+		case "-":
+			o.script = i
+			return o, nil
+		case "--":
+			if i+1 < len(args) {
+				o.script = i + 1
+			}
+			return o, nil
+		case "-i":
+			o.interactive = true
+			o.version = true
+		case "-v":
+			o.version = true
+		case "-E":
+			o.noEnv = true
+		case "-e", "-l":
+			i++
+			if i == len(args) {
+				return nil, fmt.Errorf("'%v' needs argument", arg)
+			}
+			o.exec = append(o.exec, [2]string{arg, args[i]})
+		default:
+			if strings.HasPrefix(arg, "-e") || strings.HasPrefix(arg, "-l") {
+				o.exec = append(o.exec, [2]string{arg[:2], arg[2:]})
+			} else {
+				return nil, fmt.Errorf("unrecognized option '%v'", arg)
+			}
+		}
+	}
+	return o, nil
+}
+
+// doChunk checks the chunk read from r. There is no evaluator yet, so
+// running a chunk only parses it.
+func doChunk(name string, r io.Reader) error {
+	if _, err := parser.ParseChunk(scanner.New(r)); err != nil {
+		return fmt.Errorf("%v: %v", name, err)
+	}
+	return nil
+}
+
+func doFile(name string) error {
+	if name == "-" {
+		return doChunk("stdin", os.Stdin)
+	}
+	f, err := os.Open(name)
+	if err != nil {
+		return fmt.Errorf("cannot open %v", name)
+	}
+	defer f.Close()
+	return doChunk(name, f)
+}
+
+func handleInit() error {
+	name := "LUA_INIT_5_3"
+	init, ok := os.LookupEnv(name)
+	if !ok {
+		name = "LUA_INIT"
+		init, ok = os.LookupEnv(name)
+	}
+	if !ok {
+		return nil
+	}
+	if strings.HasPrefix(init, "@") {
+		return doFile(init[1:])
+	}
+	return doChunk(name, strings.NewReader(init))
+}
+
+func isTerminal(f *os.File) bool {
+	fi, err := f.Stat()
+	return err == nil && fi.Mode()&os.ModeCharDevice != 0
+}
+
+func run(o *options, args []string) error {
+	if o.version {
+		fmt.Println(version)
+	}
+	if !o.noEnv {
+		if err := handleInit(); err != nil {
+			return err
+		}
+	}
+	for _, e := range o.exec {
+		if e[0] == "-l" {
+			return fmt.Errorf("module '%v' not found: require is not supported",
+				e[1])
+		}
+		if err := doChunk("(command line)", strings.NewReader(e[1])); err != nil {
+			return err
+		}
+	}
+	if o.script < len(args) {
+		if err := doFile(args[o.script]); err != nil {
+			return err
+		}
+	}
+	if o.interactive {
+		repl(os.Stdin, os.Stdout)
+	} else if o.script == len(args) && len(o.exec) == 0 && !o.version {
+		if isTerminal(os.Stdin) {
+			fmt.Println(version)
+			repl(os.Stdin, os.Stdout)
+		} else {
+			return doFile("-")
+		}
+	}
+	return nil
+}
+
 func main() {
-	defer func() {
-		if err := recover(); err != nil {
-			fmt.Println(err)
-		}
-	}()
-	r := strings.NewReader("local さよなら = 'Hello, 世界'")
-	s := scanner.New(r)
-	p := parser.New(s)
-	p.Parse()
+	if ok, err := subcommand(os.Args); ok {
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%v: %v\n", progName, err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	o, err := collectArgs(os.Args)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v: %v\n", progName, err)
+		fmt.Fprintf(os.Stderr, usage, progName)
+		os.Exit(1)
+	}
+	if err := run(o, os.Args); err != nil {
+		fmt.Fprintf(os.Stderr, "%v: %v\n", progName, err)
+		os.Exit(1)
+	}
 }
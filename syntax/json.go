@@ -0,0 +1,406 @@
+package syntax
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+
+	"github.com/ksco/slua/scanner"
+)
+
+// Every node encodes to a JSON object whose "type" member names the node.
+
+func marshal(typ string, node interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(node); err != nil {
+		return nil, err
+	}
+	b := bytes.TrimSpace(buf.Bytes())
+	head := `{"type":"` + typ + `"`
+	if len(b) > 2 {
+		head += ","
+	}
+	return append([]byte(head), b[1:]...), nil
+}
+
+func (n *Chunk) MarshalJSON() ([]byte, error) {
+	type node Chunk
+	return marshal("Chunk", (*node)(n))
+}
+
+func (n *Block) MarshalJSON() ([]byte, error) {
+	type node Block
+	return marshal("Block", (*node)(n))
+}
+
+func (n *DoStatement) MarshalJSON() ([]byte, error) {
+	type node DoStatement
+	return marshal("DoStatement", (*node)(n))
+}
+
+func (n *WhileStatement) MarshalJSON() ([]byte, error) {
+	type node WhileStatement
+	return marshal("WhileStatement", (*node)(n))
+}
+
+func (n *IfStatement) MarshalJSON() ([]byte, error) {
+	type node IfStatement
+	return marshal("IfStatement", (*node)(n))
+}
+
+func (n *ElseifStatement) MarshalJSON() ([]byte, error) {
+	type node ElseifStatement
+	return marshal("ElseifStatement", (*node)(n))
+}
+
+func (n *ElseStatement) MarshalJSON() ([]byte, error) {
+	type node ElseStatement
+	return marshal("ElseStatement", (*node)(n))
+}
+
+func (n *LocalNameListStatement) MarshalJSON() ([]byte, error) {
+	type node LocalNameListStatement
+	return marshal("LocalNameListStatement", (*node)(n))
+}
+
+func (n *AssignmentStatement) MarshalJSON() ([]byte, error) {
+	type node AssignmentStatement
+	return marshal("AssignmentStatement", (*node)(n))
+}
+
+func (n *VarList) MarshalJSON() ([]byte, error) {
+	type node VarList
+	return marshal("VarList", (*node)(n))
+}
+
+func (n *Terminator) MarshalJSON() ([]byte, error) {
+	type node Terminator
+	return marshal("Terminator", (*node)(n))
+}
+
+func (n *BinaryExpression) MarshalJSON() ([]byte, error) {
+	type node BinaryExpression
+	return marshal("BinaryExpression", (*node)(n))
+}
+
+func (n *UnaryExpression) MarshalJSON() ([]byte, error) {
+	type node UnaryExpression
+	return marshal("UnaryExpression", (*node)(n))
+}
+
+func (n *ParenExpression) MarshalJSON() ([]byte, error) {
+	type node ParenExpression
+	return marshal("ParenExpression", (*node)(n))
+}
+
+func (n *NameList) MarshalJSON() ([]byte, error) {
+	type node NameList
+	return marshal("NameList", (*node)(n))
+}
+
+func (n *ExpressionList) MarshalJSON() ([]byte, error) {
+	type node ExpressionList
+	return marshal("ExpressionList", (*node)(n))
+}
+
+type decoder struct {
+	obj map[string]json.RawMessage
+	err error
+}
+
+// Kinds of node that more than one node type can fill.
+const (
+	kindStatement  = "statement"
+	kindExpression = "expression"
+	kindBranch     = "elseif or else"
+)
+
+func isKind(typ, kind string) bool {
+	switch kind {
+	case kindStatement:
+		switch typ {
+		case "DoStatement", "WhileStatement", "IfStatement",
+			"LocalNameListStatement", "AssignmentStatement":
+			return true
+		}
+		return false
+	case kindExpression:
+		switch typ {
+		case "Terminator", "BinaryExpression", "UnaryExpression",
+			"ParenExpression":
+			return true
+		}
+		return false
+	case kindBranch:
+		return typ == "ElseifStatement" || typ == "ElseStatement"
+	default:
+		return typ == kind
+	}
+}
+
+// Token categories each kind of token may have.
+var (
+	terminalCategories = []string{
+		scanner.TokenNil, scanner.TokenFalse, scanner.TokenTrue,
+		scanner.TokenNumber, scanner.TokenString, scanner.TokenID,
+		scanner.TokenVarArg,
+	}
+	binaryCategories = []string{
+		scanner.TokenAdd, scanner.TokenSub, scanner.TokenMul,
+		scanner.TokenDiv, scanner.TokenConcat, scanner.TokenEqual,
+		scanner.TokenNotEqual, scanner.TokenLess, scanner.TokenLessEqual,
+		scanner.TokenGreater, scanner.TokenGreaterEqual, scanner.TokenAnd,
+		scanner.TokenOr,
+	}
+	unaryCategories = []string{
+		scanner.TokenSub, scanner.TokenNot, scanner.TokenLen,
+	}
+	nameCategories  = []string{scanner.TokenID}
+	parenCategories = []string{scanner.TokenLeftParen}
+)
+
+// Unmarshal decodes a syntax tree encoded as JSON by the MarshalJSON
+// methods. Nodes may be encoded by other tools, but they are held to what
+// the parser would build: the root is a Chunk, every node has a "type"
+// member, every field holds a node of the kind the parser puts there, only
+// an 'if' or 'elseif' may leave out its false branch and only a 'local'
+// statement its expressions, lists are not empty, and tokens have values
+// that fit their categories.
+func Unmarshal(data []byte) (SyntaxTree, error) {
+	tree, err := unmarshal(data, "Chunk")
+	if err == nil && tree == nil {
+		err = fmt.Errorf("expect Chunk node, got null")
+	}
+	return tree, err
+}
+
+// unmarshal decodes a node, which must be of kind want.
+func unmarshal(data json.RawMessage, want string) (SyntaxTree, error) {
+	if len(data) == 0 || string(data) == "null" {
+		return nil, nil
+	}
+	d := &decoder{}
+	if err := json.Unmarshal(data, &d.obj); err != nil {
+		return nil, err
+	}
+	var typ string
+	if err := json.Unmarshal(d.obj["type"], &typ); err != nil {
+		return nil, fmt.Errorf("syntax tree node without a type: %s", data)
+	}
+	if !isKind(typ, want) {
+		return nil, fmt.Errorf("expect %v node, got %v", want, typ)
+	}
+
+	var tree SyntaxTree
+	switch typ {
+	case "Chunk":
+		tree = &Chunk{Block: d.node("block", "Block")}
+	case "Block":
+		tree = &Block{Stmts: d.nodes("stmts", kindStatement)}
+	case "DoStatement":
+		tree = &DoStatement{Block: d.node("block", "Block")}
+	case "WhileStatement":
+		tree = &WhileStatement{
+			Exp:   d.node("exp", kindExpression),
+			Block: d.node("block", "Block"),
+		}
+	case "IfStatement":
+		tree = &IfStatement{
+			Exp:         d.node("exp", kindExpression),
+			TrueBranch:  d.node("trueBranch", "Block"),
+			FalseBranch: d.optional("falseBranch", kindBranch),
+		}
+	case "ElseifStatement":
+		tree = &ElseifStatement{
+			Exp:         d.node("exp", kindExpression),
+			TrueBranch:  d.node("trueBranch", "Block"),
+			FalseBranch: d.optional("falseBranch", kindBranch),
+		}
+	case "ElseStatement":
+		tree = &ElseStatement{Block: d.node("block", "Block")}
+	case "LocalNameListStatement":
+		tree = &LocalNameListStatement{
+			NameList: d.node("nameList", "NameList"),
+			ExpList:  d.optional("expList", "ExpressionList"),
+		}
+	case "AssignmentStatement":
+		tree = &AssignmentStatement{
+			VarList: d.node("varList", "VarList"),
+			ExpList: d.node("expList", "ExpressionList"),
+		}
+	case "VarList":
+		list := d.list("varList", "Terminator")
+		for _, v := range list {
+			if d.err == nil {
+				d.checkToken("varList", v.(*Terminator).Token,
+					nameCategories)
+			}
+		}
+		tree = &VarList{VarList: list}
+	case "Terminator":
+		tree = &Terminator{Token: d.token("token", terminalCategories)}
+	case "BinaryExpression":
+		tree = &BinaryExpression{
+			Left:    d.node("left", kindExpression),
+			Right:   d.node("right", kindExpression),
+			OpToken: d.token("opToken", binaryCategories),
+		}
+	case "UnaryExpression":
+		tree = &UnaryExpression{
+			Exp:     d.node("exp", kindExpression),
+			OpToken: d.token("opToken", unaryCategories),
+		}
+	case "ParenExpression":
+		tree = &ParenExpression{
+			Exp:       d.node("exp", kindExpression),
+			LeftParen: d.token("leftParen", parenCategories),
+		}
+	case "NameList":
+		tree = &NameList{Names: d.tokens("names", nameCategories)}
+	case "ExpressionList":
+		tree = &ExpressionList{ExpList: d.list("expList", kindExpression)}
+	default:
+		return nil, fmt.Errorf("unknown syntax tree node type %v", typ)
+	}
+	if d.err != nil {
+		return nil, d.err
+	}
+	return tree, nil
+}
+
+// node decodes a node that must be present.
+func (d *decoder) node(key, want string) SyntaxTree {
+	tree := d.optional(key, want)
+	if d.err == nil && tree == nil {
+		d.err = fmt.Errorf("missing %v", key)
+	}
+	return tree
+}
+
+// optional decodes a node that may be missing or null.
+func (d *decoder) optional(key, want string) SyntaxTree {
+	if d.err != nil {
+		return nil
+	}
+	tree, err := unmarshal(d.obj[key], want)
+	if err != nil {
+		d.err = err
+	}
+	return tree
+}
+
+// nodes decodes a list of nodes, which may be empty.
+func (d *decoder) nodes(key, want string) []SyntaxTree {
+	if d.err != nil {
+		return nil
+	}
+	var list []json.RawMessage
+	if data, ok := d.obj[key]; ok {
+		if d.err = json.Unmarshal(data, &list); d.err != nil {
+			return nil
+		}
+	}
+	var trees []SyntaxTree
+	for _, data := range list {
+		tree, err := unmarshal(data, want)
+		if err == nil && tree == nil {
+			err = fmt.Errorf("unexpect null in %v", key)
+		}
+		if err != nil {
+			d.err = err
+			return nil
+		}
+		trees = append(trees, tree)
+	}
+	return trees
+}
+
+// list decodes a list of nodes that must not be empty.
+func (d *decoder) list(key, want string) []SyntaxTree {
+	trees := d.nodes(key, want)
+	if d.err == nil && len(trees) == 0 {
+		d.err = fmt.Errorf("empty %v", key)
+	}
+	return trees
+}
+
+func (d *decoder) token(key string, categories []string) *scanner.Token {
+	if d.err != nil {
+		return nil
+	}
+	t := &scanner.Token{}
+	data, ok := d.obj[key]
+	if !ok || string(data) == "null" {
+		d.err = fmt.Errorf("missing token %v", key)
+		return nil
+	}
+	if d.err = json.Unmarshal(data, t); d.err != nil {
+		return nil
+	}
+	d.checkToken(key, t, categories)
+	return t
+}
+
+func (d *decoder) tokens(key string, categories []string) []*scanner.Token {
+	if d.err != nil {
+		return nil
+	}
+	var tokens []*scanner.Token
+	if data, ok := d.obj[key]; ok {
+		if d.err = json.Unmarshal(data, &tokens); d.err != nil {
+			return nil
+		}
+	}
+	if len(tokens) == 0 {
+		d.err = fmt.Errorf("empty %v", key)
+		return nil
+	}
+	for _, t := range tokens {
+		if t == nil {
+			d.err = fmt.Errorf("unexpect null in %v", key)
+			return nil
+		}
+		if d.checkToken(key, t, categories); d.err != nil {
+			return nil
+		}
+	}
+	return tokens
+}
+
+// checkToken checks that t is one of categories and that its value
+// fits its category.
+func (d *decoder) checkToken(key string, t *scanner.Token,
+	categories []string) {
+	if t.Category == "" {
+		d.err = fmt.Errorf("token %v without a category", key)
+		return
+	}
+	found := false
+	for _, c := range categories {
+		found = found || t.Category == c
+	}
+	if !found {
+		d.err = fmt.Errorf("unexpect token %v in %v", t.Category, key)
+		return
+	}
+	switch t.Category {
+	case scanner.TokenID:
+		if s, ok := t.Value.(string); !ok || !scanner.IsName(s) {
+			d.err = fmt.Errorf("invalid name %#v in %v", t.Value, key)
+		}
+	case scanner.TokenString:
+		if _, ok := t.Value.(string); !ok {
+			d.err = fmt.Errorf("%v token with %T value in %v",
+				t.Category, t.Value, key)
+		}
+	case scanner.TokenNumber:
+		switch t.Value.(type) {
+		case int64, float64:
+		default:
+			d.err = fmt.Errorf("%v token with %T value in %v",
+				t.Category, t.Value, key)
+		}
+	}
+}
@@ -0,0 +1,278 @@
+package syntax_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/ksco/slua/parser"
+	"github.com/ksco/slua/printer"
+	"github.com/ksco/slua/scanner"
+	"github.com/ksco/slua/syntax"
+)
+
+const roundTripSource = `local a, b = 1, 2.5, "", "\xFF"
+local c
+if a < b and not c then
+    a = -a .. #"x"
+elseif b then
+    b = ...
+    a, b = (...), ((a))
+else
+    c = nil ~= true
+end
+while false do
+    do
+        local d = (a + b) * 2 / 3
+    end
+end
+`
+
+func TestRoundTrip(t *testing.T) {
+	s := scanner.New(strings.NewReader(roundTripSource))
+	tree := parser.New(s).Parse()
+	data, err := json.Marshal(tree)
+	if err != nil {
+		t.Fatal(err)
+	}
+	decoded, err := syntax.Unmarshal(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	again, err := json.Marshal(decoded)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(data, again) {
+		t.Errorf("encoding changed after decoding:\n%s\n%s", data, again)
+	}
+	var b strings.Builder
+	printer.Fprint(&b, decoded)
+	if b.String() != roundTripSource {
+		t.Errorf("printed\n%v\nwant\n%v", b.String(), roundTripSource)
+	}
+	b.Reset()
+	syntax.Fprint(&b, decoded)
+	if !strings.Contains(b.String(), "ParenExpression ( 7:12") {
+		t.Errorf("tree dump has no parentheses:\n%v", b.String())
+	}
+}
+
+// Helpers for building JSON trees by hand.
+
+func token(category, value string) string {
+	if value == "" {
+		return `{"category":"` + category + `"}`
+	}
+	return `{"category":"` + category + `","value":` + value + `}`
+}
+
+func name(n string) string {
+	return `{"type":"Terminator","token":` + token("<id>", `"`+n+`"`) + `}`
+}
+
+func number(value string) string {
+	return `{"type":"Terminator","token":` + token("<number>", value) + `}`
+}
+
+func chunk(stmts ...string) string {
+	return `{"type":"Chunk","block":{"type":"Block","stmts":[` +
+		strings.Join(stmts, ",") + `]}}`
+}
+
+func expList(exps ...string) string {
+	return `{"type":"ExpressionList","expList":[` +
+		strings.Join(exps, ",") + `]}`
+}
+
+func local(names, exps string) string {
+	s := `{"type":"LocalNameListStatement"`
+	if names != "" {
+		s += `,"nameList":{"type":"NameList","names":[` + names + `]}`
+	}
+	if exps != "" {
+		s += `,"expList":` + exps
+	}
+	return s + `}`
+}
+
+func TestUnmarshalAccepts(t *testing.T) {
+	tests := []string{
+		chunk(),
+		chunk(local(token("<id>", `"a"`), "")),
+		chunk(local(token("<id>", `"_x1"`),
+			expList(number("1"), number("1.5")))),
+		chunk(`{"type":"IfStatement","exp":` + name("a") +
+			`,"trueBranch":{"type":"Block","stmts":[]}}`),
+	}
+	for _, data := range tests {
+		if _, err := syntax.Unmarshal([]byte(data)); err != nil {
+			t.Errorf("Unmarshal(%v): %v", data, err)
+		}
+	}
+}
+
+func TestUnmarshalRejects(t *testing.T) {
+	emptyBlock := `{"type":"Block","stmts":[]}`
+	tests := []struct {
+		name, data, err string
+	}{
+		{
+			"expression as statement",
+			chunk(name("a")),
+			"expect statement node, got Terminator",
+		},
+		{
+			"local without names",
+			chunk(local("", expList(number("1")))),
+			"missing nameList",
+		},
+		{
+			"local with empty names",
+			chunk(`{"type":"LocalNameListStatement",` +
+				`"nameList":{"type":"NameList","names":[]}}`),
+			"empty names",
+		},
+		{
+			"while without exp",
+			chunk(`{"type":"WhileStatement","block":` + emptyBlock + `}`),
+			"missing exp",
+		},
+		{
+			"while with statement as exp",
+			chunk(`{"type":"WhileStatement","exp":{"type":"DoStatement",` +
+				`"block":` + emptyBlock + `},"block":` + emptyBlock + `}`),
+			"expect expression node, got DoStatement",
+		},
+		{
+			"if with block as false branch",
+			chunk(`{"type":"IfStatement","exp":` + name("a") +
+				`,"trueBranch":` + emptyBlock +
+				`,"falseBranch":` + emptyBlock + `}`),
+			"expect elseif or else node, got Block",
+		},
+		{
+			"binary without right",
+			chunk(local(token("<id>", `"a"`), expList(
+				`{"type":"BinaryExpression","left":`+number("1")+
+					`,"opToken":`+token("+", "")+`}`))),
+			"missing right",
+		},
+		{
+			"binary with unary operator",
+			chunk(local(token("<id>", `"a"`), expList(
+				`{"type":"BinaryExpression","left":`+number("1")+
+					`,"right":`+number("2")+
+					`,"opToken":`+token("#", "")+`}`))),
+			"unexpect token # in opToken",
+		},
+		{
+			"assignment without varList",
+			chunk(`{"type":"AssignmentStatement","expList":` +
+				expList(number("1")) + `}`),
+			"missing varList",
+		},
+		{
+			"assignment without expList",
+			chunk(`{"type":"AssignmentStatement","varList":` +
+				`{"type":"VarList","varList":[` + name("a") + `]}}`),
+			"missing expList",
+		},
+		{
+			"assignment to a number",
+			chunk(`{"type":"AssignmentStatement","varList":` +
+				`{"type":"VarList","varList":[` + number("1") + `]},` +
+				`"expList":` + expList(number("1")) + `}`),
+			"unexpect token <number> in varList",
+		},
+		{
+			"assignment to an expression",
+			chunk(`{"type":"AssignmentStatement","varList":` +
+				`{"type":"VarList","varList":[{"type":"UnaryExpression",` +
+				`"exp":` + name("a") + `,"opToken":` + token("-", "") +
+				`}]},"expList":` + expList(number("1")) + `}`),
+			"expect Terminator node, got UnaryExpression",
+		},
+		{
+			"parentheses without a token",
+			chunk(local(token("<id>", `"a"`), expList(
+				`{"type":"ParenExpression","exp":`+name("b")+`}`))),
+			"missing token leftParen",
+		},
+		{
+			"parentheses with the wrong token",
+			chunk(local(token("<id>", `"a"`), expList(
+				`{"type":"ParenExpression","exp":`+name("b")+
+					`,"leftParen":`+token(")", "")+`}`))),
+			"unexpect token ) in leftParen",
+		},
+		{
+			"empty expression list",
+			chunk(local(token("<id>", `"a"`), expList())),
+			"empty expList",
+		},
+		{
+			"number with a string value",
+			chunk(local(token("<id>", `"a"`), expList(number(`"1"`)))),
+			"<number> token with string value",
+		},
+		{
+			"string with a number value",
+			chunk(local(token("<id>", `"a"`), expList(
+				`{"type":"Terminator","token":`+
+					token("<string>", "1")+`}`))),
+			"<string> token with int64 value",
+		},
+		{
+			"name with a space",
+			chunk(local(token("<id>", `"a b"`), "")),
+			`invalid name "a b"`,
+		},
+		{
+			"keyword as name",
+			chunk(local(token("<id>", `"end"`), "")),
+			`invalid name "end"`,
+		},
+		{
+			"name starting with a digit",
+			chunk(local(token("<id>", `"1a"`), "")),
+			`invalid name "1a"`,
+		},
+		{
+			"name token without a value",
+			chunk(local(token("<id>", ""), "")),
+			"invalid name <nil>",
+		},
+		{
+			"operator as name",
+			chunk(local(token("+", ""), "")),
+			"unexpect token + in names",
+		},
+
+		// Printing needs a whole chunk, so nothing else is accepted at
+		// the root.
+		{"terminator at the root", name("a"),
+			"expect Chunk node, got Terminator"},
+		{"block at the root", emptyBlock, "expect Chunk node, got Block"},
+		{"statement at the root", local(token("<id>", `"a"`), ""),
+			"expect Chunk node, got LocalNameListStatement"},
+		{"else at the root",
+			`{"type":"ElseStatement","block":` + emptyBlock + `}`,
+			"expect Chunk node, got ElseStatement"},
+		{"name list at the root",
+			`{"type":"NameList","names":[` + token("<id>", `"a"`) + `]}`,
+			"expect Chunk node, got NameList"},
+		{"expression list at the root", expList(number("1")),
+			"expect Chunk node, got ExpressionList"},
+		{"null at the root", "null", "expect Chunk node, got null"},
+	}
+	for _, test := range tests {
+		_, err := syntax.Unmarshal([]byte(test.data))
+		if err == nil {
+			t.Errorf("%v: no error", test.name)
+		} else if !strings.Contains(err.Error(), test.err) {
+			t.Errorf("%v: error %q, want %q", test.name, err, test.err)
+		}
+	}
+}
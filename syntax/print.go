@@ -0,0 +1,149 @@
+package syntax
+
+import (
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/ksco/slua/scanner"
+)
+
+type printer struct {
+	w     io.Writer
+	depth int
+}
+
+// Fprint writes tree to w as an indented outline, one node per line.
+func Fprint(w io.Writer, tree SyntaxTree) {
+	p := &printer{w: w}
+	p.node("", tree)
+}
+
+// FormatToken returns the category, value and position of t on one line.
+func FormatToken(t *scanner.Token) string {
+	switch v := t.Value.(type) {
+	case nil:
+		return fmt.Sprintf("%v %v:%v", t.Category, t.Line, t.Column)
+	case string:
+		return fmt.Sprintf("%v %q %v:%v", t.Category, v, t.Line, t.Column)
+	default:
+		return fmt.Sprintf("%v %v %v:%v", t.Category, v, t.Line, t.Column)
+	}
+}
+
+func (p *printer) line(label, text string) {
+	fmt.Fprint(p.w, strings.Repeat("  ", p.depth))
+	if label != "" {
+		fmt.Fprint(p.w, label, ": ")
+	}
+	fmt.Fprintln(p.w, text)
+}
+
+func (p *printer) children(f func()) {
+	p.depth++
+	f()
+	p.depth--
+}
+
+func (p *printer) node(label string, tree SyntaxTree) {
+	switch t := tree.(type) {
+	case nil:
+		p.line(label, "nil")
+	case *Chunk:
+		p.line(label, "Chunk")
+		p.children(func() {
+			p.node("Block", t.Block)
+		})
+	case *Block:
+		p.line(label, "Block")
+		p.children(func() {
+			for _, stmt := range t.Stmts {
+				p.node("", stmt)
+			}
+		})
+	case *DoStatement:
+		p.line(label, "DoStatement")
+		p.children(func() {
+			p.node("Block", t.Block)
+		})
+	case *WhileStatement:
+		p.line(label, "WhileStatement")
+		p.children(func() {
+			p.node("Exp", t.Exp)
+			p.node("Block", t.Block)
+		})
+	case *IfStatement:
+		p.line(label, "IfStatement")
+		p.children(func() {
+			p.node("Exp", t.Exp)
+			p.node("TrueBranch", t.TrueBranch)
+			p.node("FalseBranch", t.FalseBranch)
+		})
+	case *ElseifStatement:
+		p.line(label, "ElseifStatement")
+		p.children(func() {
+			p.node("Exp", t.Exp)
+			p.node("TrueBranch", t.TrueBranch)
+			p.node("FalseBranch", t.FalseBranch)
+		})
+	case *ElseStatement:
+		p.line(label, "ElseStatement")
+		p.children(func() {
+			p.node("Block", t.Block)
+		})
+	case *LocalNameListStatement:
+		p.line(label, "LocalNameListStatement")
+		p.children(func() {
+			p.node("NameList", t.NameList)
+			p.node("ExpList", t.ExpList)
+		})
+	case *AssignmentStatement:
+		p.line(label, "AssignmentStatement")
+		p.children(func() {
+			p.node("VarList", t.VarList)
+			p.node("ExpList", t.ExpList)
+		})
+	case *VarList:
+		p.line(label, "VarList")
+		p.children(func() {
+			for _, v := range t.VarList {
+				p.node("", v)
+			}
+		})
+	case *Terminator:
+		p.line(label, "Terminator "+FormatToken(t.Token))
+	case *BinaryExpression:
+		p.line(label, "BinaryExpression "+FormatToken(t.OpToken))
+		p.children(func() {
+			p.node("Left", t.Left)
+			p.node("Right", t.Right)
+		})
+	case *UnaryExpression:
+		p.line(label, "UnaryExpression "+FormatToken(t.OpToken))
+		p.children(func() {
+			p.node("Exp", t.Exp)
+		})
+	case *ParenExpression:
+		p.line(label, "ParenExpression "+FormatToken(t.LeftParen))
+		p.children(func() {
+			p.node("Exp", t.Exp)
+		})
+	case *NameList:
+		p.line(label, "NameList")
+		p.children(func() {
+			for _, name := range t.Names {
+				p.line("", FormatToken(name))
+			}
+		})
+	case *ExpressionList:
+		p.line(label, "ExpressionList")
+		p.children(func() {
+			for _, exp := range t.ExpList {
+				p.node("", exp)
+			}
+		})
+	default:
+		panic(fmt.Sprintf("slua/syntax internal error: unknown syntax tree %T",
+			tree))
+	}
+}
@@ -2,76 +2,108 @@ package syntax
 
 import "github.com/ksco/slua/scanner"
 
+// SyntaxTree is a node of a parsed chunk. Nothing changes a tree once it is
+// parsed except optimizer.Optimize, which rewrites it in place, so a tree
+// can be resolved, printed, linted and encoded from many goroutines at once.
+// Optimize a tree before sharing it, not after.
 type SyntaxTree interface{}
 
 type (
 	Chunk struct {
-		Block SyntaxTree
+		Block SyntaxTree `json:"block"`
 	}
 
 	Block struct {
-		Stmts []SyntaxTree
+		Stmts []SyntaxTree `json:"stmts"`
 	}
 
 	DoStatement struct {
-		Block SyntaxTree
+		Block SyntaxTree `json:"block"`
 	}
 
 	WhileStatement struct {
-		Exp   SyntaxTree
-		Block SyntaxTree
+		Exp   SyntaxTree `json:"exp"`
+		Block SyntaxTree `json:"block"`
 	}
 
 	IfStatement struct {
-		Exp         SyntaxTree
-		TrueBranch  SyntaxTree
-		FalseBranch SyntaxTree
+		Exp         SyntaxTree `json:"exp"`
+		TrueBranch  SyntaxTree `json:"trueBranch"`
+		FalseBranch SyntaxTree `json:"falseBranch"`
 	}
 
 	ElseifStatement struct {
-		Exp         SyntaxTree
-		TrueBranch  SyntaxTree
-		FalseBranch SyntaxTree
+		Exp         SyntaxTree `json:"exp"`
+		TrueBranch  SyntaxTree `json:"trueBranch"`
+		FalseBranch SyntaxTree `json:"falseBranch"`
 	}
 
 	ElseStatement struct {
-		Block SyntaxTree
+		Block SyntaxTree `json:"block"`
 	}
 
 	LocalNameListStatement struct {
-		NameList SyntaxTree
-		ExpList  SyntaxTree
+		NameList SyntaxTree `json:"nameList"`
+		ExpList  SyntaxTree `json:"expList"`
 	}
 
 	AssignmentStatement struct {
-		VarList SyntaxTree
-		ExpList SyntaxTree
+		VarList SyntaxTree `json:"varList"`
+		ExpList SyntaxTree `json:"expList"`
 	}
 
 	VarList struct {
-		VarList []SyntaxTree
+		VarList []SyntaxTree `json:"varList"`
 	}
 
 	Terminator struct {
-		Token *scanner.Token
+		Token *scanner.Token `json:"token"`
 	}
 
 	BinaryExpression struct {
-		Left    SyntaxTree
-		Right   SyntaxTree
-		OpToken *scanner.Token
+		Left    SyntaxTree     `json:"left"`
+		Right   SyntaxTree     `json:"right"`
+		OpToken *scanner.Token `json:"opToken"`
 	}
 
 	UnaryExpression struct {
-		Exp     SyntaxTree
-		OpToken *scanner.Token
+		Exp     SyntaxTree     `json:"exp"`
+		OpToken *scanner.Token `json:"opToken"`
+	}
+
+	// ParenExpression is a parenthesized expression. The parentheses are
+	// kept because '(...)' gives only the first of the values '...' gives.
+	ParenExpression struct {
+		Exp       SyntaxTree     `json:"exp"`
+		LeftParen *scanner.Token `json:"leftParen"`
 	}
 
 	NameList struct {
-		Names []*scanner.Token
+		Names []*scanner.Token `json:"names"`
 	}
 
 	ExpressionList struct {
-		ExpList []SyntaxTree
+		ExpList []SyntaxTree `json:"expList"`
 	}
 )
+
+// IsVarArg reports whether exp is a bare '...', which gives all the extra
+// arguments of the chunk rather than one value.
+func IsVarArg(exp SyntaxTree) bool {
+	t, ok := exp.(*Terminator)
+	return ok && t.Token.Category == scanner.TokenVarArg
+}
+
+// FirstToken returns the leftmost token of the expression exp.
+func FirstToken(exp SyntaxTree) *scanner.Token {
+	switch t := exp.(type) {
+	case *BinaryExpression:
+		return FirstToken(t.Left)
+	case *UnaryExpression:
+		return t.OpToken
+	case *ParenExpression:
+		return t.LeftParen
+	default:
+		return exp.(*Terminator).Token
+	}
+}